@@ -5,16 +5,24 @@ import (
 	"flag"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/go-redis/redis/v7"
-	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/juicyluv/astral/configs"
+	"github.com/juicyluv/astral/internal/blob"
+	"github.com/juicyluv/astral/internal/feed"
+	"github.com/juicyluv/astral/internal/keys"
+	"github.com/juicyluv/astral/internal/oidc"
 	"github.com/juicyluv/astral/internal/queue"
+	"github.com/juicyluv/astral/internal/scheduler"
+	"github.com/juicyluv/astral/internal/secretbox"
 	"github.com/juicyluv/astral/internal/server"
 	"github.com/juicyluv/astral/internal/store/postgres"
+	"github.com/juicyluv/astral/internal/sweeper"
 	"go.uber.org/zap"
 )
 
@@ -41,8 +49,29 @@ func main() {
 	// Create config instance
 	config := server.NewConfig(*configPath)
 
-	// Create database connection
-	conn, err := pgx.Connect(context.Background(), config.DbDSN)
+	// Load token signing keys. The server must not run without them
+	keyManager, err := keys.NewKeyManager(keys.NewConfig())
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Info("signing keys have been loaded")
+
+	// Secrets which are stored in database are encrypted with this key
+	box, err := secretbox.NewBox(os.Getenv("ENCRYPTION_KEY"))
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	// OpenID Connect providers for social login
+	oidcConfigs, err := oidc.NewConfigs()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	providers := oidc.NewProviders(oidcConfigs)
+
+	// Create database connection pool. Connections are shared
+	// by request handlers and background jobs
+	conn, err := pgxpool.Connect(context.Background(), config.DbDSN)
 	if err != nil {
 		logger.Fatal(err)
 	}
@@ -71,16 +100,33 @@ func main() {
 	}
 	logger.Info("queue has been connected")
 
+	// Uploaded images and files are kept in the blob store
+	blobs, err := blob.NewStore(blob.NewConfig())
+	if err != nil {
+		logger.Fatal(err)
+	}
+
 	// Create Postgres repository
 	store := postgres.NewPostgres(conn, logger)
 
+	// Home feed timelines are kept in Redis
+	timelines := feed.NewTimelines(redis, store, logger, feed.NewConfig())
+
 	// Create and configure http server
-	server := server.NewServer(&config, logger, store, redis, queue)
+	server := server.NewServer(&config, logger, store, redis, queue, keyManager, box, timelines, blobs, providers)
 
 	// OS Signal Notification Context
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// Publish scheduled posts in background until shutdown
+	scheduler := scheduler.NewScheduler(store, queue, timelines, logger, scheduler.NewConfig())
+	go scheduler.Run(ctx)
+
+	// Remove files of deleted attachments in background until shutdown
+	sweeper := sweeper.NewSweeper(store, blobs, logger, sweeper.NewConfig())
+	go sweeper.Run(ctx)
+
 	// Run the server
 	go func() {
 		if err := server.Run(); err != nil && err != http.ErrServerClosed {
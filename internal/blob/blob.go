@@ -0,0 +1,69 @@
+// Package blob stores binary objects, like uploaded images and files,
+// by keys. Keys are slash separated paths, e.g. "avatars/<hash>.png".
+//
+// The Store interface follows the semantics of S3-compatible object
+// storages, so objects can be moved there by adding a Store driver.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+// ErrNotFound is returned when there is no object with the key
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidKey is returned for keys which can't be used as object paths
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// keyPattern matches relative slash separated paths without
+// empty, "." or ".." segments
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_\-]+(\.[A-Za-z0-9_\-]+)*(/[A-Za-z0-9_\-]+(\.[A-Za-z0-9_\-]+)*)*$`)
+
+// Object is an object read from the store. Content supports seeking,
+// so objects can be served with range requests. It must be closed.
+type Object struct {
+	Content     io.ReadSeekCloser
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Store keeps objects by keys. Objects are written at once and never
+// modified, writing an object with an existing key replaces it.
+type Store interface {
+	Put(ctx context.Context, key string, content io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (*Object, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Store drivers
+const (
+	DriverLocal = "local"
+	DriverS3    = "s3"
+)
+
+// NewStore returns the store of the driver from config
+func NewStore(cfg *Config) (Store, error) {
+	switch cfg.Driver {
+	case DriverLocal:
+		return NewLocalStore(cfg.LocalDir)
+	case DriverS3:
+		return nil, errors.New("s3 blob store is not supported yet")
+	default:
+		return nil, fmt.Errorf("unknown blob store driver %q", cfg.Driver)
+	}
+}
+
+// validateKey returns ErrInvalidKey when the key can't be used as an object path
+func validateKey(key string) error {
+	if !keyPattern.MatchString(key) {
+		return ErrInvalidKey
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package blob
+
+import "github.com/spf13/viper"
+
+type Config struct {
+	// Driver is the storage of the objects, local or s3
+	Driver string
+	// LocalDir is the root directory of the local store
+	LocalDir string
+}
+
+func NewConfig() *Config {
+	return &Config{
+		Driver:   viper.GetString("blob.driver"),
+		LocalDir: viper.GetString("blob.localDir"),
+	}
+}
@@ -0,0 +1,262 @@
+// Package diff computes line differences between texts and
+// formats them as unified diffs.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxEdits limits the edit distance which is searched for the shortest
+// edit script. When texts differ more, the changed part is reported as
+// removed and added as a whole. The trace of the search grows with the
+// square of the edit distance, 500 edits keep it within about 2 MB.
+const maxEdits = 500
+
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+// op is a single line of the edit script. A and B are
+// positions of the line in the old and the new text.
+type op struct {
+	kind opKind
+	a, b int
+	line string
+}
+
+// Unified returns a unified diff between the texts with the given
+// number of context lines around changes. It returns an empty string
+// when the texts are equal.
+func Unified(fromName, toName, from, to string, context int) string {
+	ops := compute(strings.Split(from, "\n"), strings.Split(to, "\n"))
+
+	var sb strings.Builder
+	for _, hunk := range hunks(ops, context) {
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		writeHunk(&sb, hunk)
+	}
+
+	return sb.String()
+}
+
+// compute returns the edit script which turns a into b. Common prefix and
+// suffix are skipped, the rest is compared with the Myers algorithm.
+func compute(a, b []string) []op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, op{kind: opEqual, a: i, b: i, line: a[i]})
+	}
+
+	middle := myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	for _, o := range middle {
+		o.a += prefix
+		o.b += prefix
+		ops = append(ops, o)
+	}
+
+	for i := suffix; i > 0; i-- {
+		ops = append(ops, op{kind: opEqual, a: len(a) - i, b: len(b) - i, line: a[len(a)-i]})
+	}
+
+	return ops
+}
+
+// myers finds the shortest edit script between a and b. Only the explored
+// part of the diagonals is kept for every edit distance to go back through.
+func myers(a, b []string) []op {
+	n, m := len(a), len(b)
+	limit := n + m
+	if limit > maxEdits {
+		limit = maxEdits
+	}
+
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	trace := make([][]int, 0)
+
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(a, b, trace)
+			}
+		}
+	}
+
+	return replaceAll(a, b)
+}
+
+// backtrack walks the trace from the end of both texts to
+// the start and collects the edit script in reverse.
+func backtrack(a, b []string, trace [][]int) []op {
+	x, y := len(a), len(b)
+	ops := make([]op, 0, len(a)+len(b))
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		if d == 0 {
+			for x > 0 {
+				x--
+				y--
+				ops = append(ops, op{kind: opEqual, a: x, b: y, line: a[x]})
+			}
+			break
+		}
+
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[k-1+d] < v[k+1+d]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[prevK+d]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, op{kind: opEqual, a: x, b: y, line: a[x]})
+		}
+
+		if x == prevX {
+			ops = append(ops, op{kind: opInsert, a: x, b: prevY, line: b[prevY]})
+		} else {
+			ops = append(ops, op{kind: opDelete, a: prevX, b: y, line: a[prevX]})
+		}
+
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+
+	return ops
+}
+
+// replaceAll is the edit script which removes every line of a and adds every line of b
+func replaceAll(a, b []string) []op {
+	ops := make([]op, 0, len(a)+len(b))
+	for i, line := range a {
+		ops = append(ops, op{kind: opDelete, a: i, b: 0, line: line})
+	}
+	for i, line := range b {
+		ops = append(ops, op{kind: opInsert, a: len(a), b: i, line: line})
+	}
+	return ops
+}
+
+// hunks groups changes which are close to each other
+// together with their surrounding context lines.
+func hunks(ops []op, context int) [][]op {
+	result := make([][]op, 0)
+	start, end := -1, -1
+
+	for i, o := range ops {
+		if o.kind == opEqual {
+			continue
+		}
+
+		from := i - context
+		if from < 0 {
+			from = 0
+		}
+
+		// Too many equal lines since the last change start a new hunk
+		if start >= 0 && from > end {
+			result = append(result, ops[start:end])
+			start = -1
+		}
+		if start < 0 {
+			start = from
+		}
+
+		end = i + context + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+	}
+
+	if start >= 0 {
+		result = append(result, ops[start:end])
+	}
+
+	return result
+}
+
+// writeHunk writes the hunk header and lines of the hunk
+func writeHunk(sb *strings.Builder, hunk []op) {
+	aStart, bStart := hunk[0].a, hunk[0].b
+	aCount, bCount := 0, 0
+	for _, o := range hunk {
+		if o.kind != opInsert {
+			aCount++
+		}
+		if o.kind != opDelete {
+			bCount++
+		}
+	}
+
+	fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+
+	for _, o := range hunk {
+		switch o.kind {
+		case opEqual:
+			sb.WriteString(" ")
+		case opDelete:
+			sb.WriteString("-")
+		case opInsert:
+			sb.WriteString("+")
+		}
+		sb.WriteString(o.line)
+		sb.WriteString("\n")
+	}
+}
+
+// hunkRange formats the line range of the hunk, the way diff utilities do.
+// Lines are numbered from 1, empty ranges point to the line before them.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
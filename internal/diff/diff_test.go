@@ -0,0 +1,128 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     string
+		to       string
+		context  int
+		expected string
+	}{
+		{
+			name:     "equal",
+			from:     "a\nb\nc",
+			to:       "a\nb\nc",
+			context:  3,
+			expected: "",
+		},
+		{
+			name:    "insert only",
+			from:    "a\nb\nc",
+			to:      "a\nb\nx\ny\nc",
+			context: 1,
+			expected: "--- from\n+++ to\n" +
+				"@@ -2,2 +2,4 @@\n b\n+x\n+y\n c\n",
+		},
+		{
+			name:    "insert at start without context",
+			from:    "b\nc",
+			to:      "a\nb\nc",
+			context: 0,
+			expected: "--- from\n+++ to\n" +
+				"@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name:    "delete only",
+			from:    "a\nb\nc\nd",
+			to:      "a\nd",
+			context: 1,
+			expected: "--- from\n+++ to\n" +
+				"@@ -1,4 +1,2 @@\n a\n-b\n-c\n d\n",
+		},
+		{
+			name:    "delete at end without context",
+			from:    "a\nb\nc",
+			to:      "a\nb",
+			context: 0,
+			expected: "--- from\n+++ to\n" +
+				"@@ -3 +2,0 @@\n-c\n",
+		},
+		{
+			name:    "replace",
+			from:    "a\nb\nc",
+			to:      "a\nx\nc",
+			context: 1,
+			expected: "--- from\n+++ to\n" +
+				"@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		{
+			name:    "close changes are merged",
+			from:    "1\n2\n3\n4\n5\n6\n7",
+			to:      "1\nx\n3\n4\n5\ny\n7",
+			context: 2,
+			expected: "--- from\n+++ to\n" +
+				"@@ -1,7 +1,7 @@\n 1\n-2\n+x\n 3\n 4\n 5\n-6\n+y\n 7\n",
+		},
+		{
+			name:    "distant changes are split",
+			from:    "1\n2\n3\n4\n5\n6\n7\n8\n9",
+			to:      "1\nx\n3\n4\n5\n6\n7\ny\n9",
+			context: 1,
+			expected: "--- from\n+++ to\n" +
+				"@@ -1,3 +1,3 @@\n 1\n-2\n+x\n 3\n" +
+				"@@ -7,3 +7,3 @@\n 7\n-8\n+y\n 9\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Unified("from", "to", tt.from, tt.to, tt.context)
+			if result != tt.expected {
+				t.Errorf("Unified()\n got %q\nwant %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestComputeIsShortest(t *testing.T) {
+	a := strings.Split("a b c a b b a", " ")
+	b := strings.Split("c b a b a c", " ")
+
+	// The shortest edit script of the example of the Myers paper has 5 edits
+	edits := 0
+	for _, o := range compute(a, b) {
+		if o.kind != opEqual {
+			edits++
+		}
+	}
+
+	if edits != 5 {
+		t.Errorf("expected 5 edits, got %d", edits)
+	}
+}
+
+func TestComputeTooManyEdits(t *testing.T) {
+	a := make([]string, 0, maxEdits)
+	b := make([]string, 0, maxEdits)
+	for i := 0; i < maxEdits; i++ {
+		a = append(a, fmt.Sprintf("a%d", i))
+		b = append(b, fmt.Sprintf("b%d", i))
+	}
+
+	ops := compute(a, b)
+	if len(ops) != len(a)+len(b) {
+		t.Fatalf("expected %d ops, got %d", len(a)+len(b), len(ops))
+	}
+
+	for i, o := range ops {
+		if (i < len(a)) != (o.kind == opDelete) {
+			t.Fatalf("expected every line removed and then added, op %d is %+v", i, o)
+		}
+	}
+}
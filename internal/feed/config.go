@@ -0,0 +1,22 @@
+package feed
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	// TimelineSize is the maximum number of posts kept in a timeline,
+	// older posts are read from the database
+	TimelineSize int
+	// TimelineTTL is how long timelines of inactive users are kept
+	TimelineTTL time.Duration
+}
+
+func NewConfig() *Config {
+	return &Config{
+		TimelineSize: viper.GetInt("feed.timelineSize"),
+		TimelineTTL:  time.Duration(viper.GetInt("feed.timelineTtl")) * time.Hour,
+	}
+}
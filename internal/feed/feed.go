@@ -0,0 +1,325 @@
+// Package feed keeps home feed timelines of the users in Redis.
+//
+// A timeline is a sorted set of post ids scored by the publishing time.
+// Published posts are written to the timelines of every follower of
+// their authors (fan-out on write), so feeds are read without joins.
+// Timelines are built on the first read and expire when users are
+// inactive, feeds without a timeline are read from the database.
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/juicyluv/astral/internal/handler/filter"
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/juicyluv/astral/internal/store"
+	"go.uber.org/zap"
+)
+
+const (
+	// fanOutBatch is the number of timelines written in a single round trip
+	fanOutBatch = 500
+	// buildTimeout limits building of a single timeline
+	buildTimeout = 30 * time.Second
+)
+
+// addScript adds the post to the timeline only if the timeline exists,
+// so timelines which haven't been built yet don't get partial content.
+// The oldest posts are removed above the size limit. While the timeline
+// is being built the post is kept aside, so the build doesn't lose posts
+// published after it has read the database.
+var addScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+	redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -tonumber(ARGV[3]) - 1)
+elseif redis.call('EXISTS', KEYS[2]) == 1 then
+	redis.call('ZADD', KEYS[3], ARGV[1], ARGV[2])
+	redis.call('EXPIRE', KEYS[3], ARGV[4])
+end
+return 0`)
+
+// buildScript writes the built timeline together with the posts which have
+// been published during the build. Nothing is written when the timeline has
+// been invalidated since the build has read its generation, the timeline
+// would be stale then. Members are passed as score and member pairs after
+// the generation, the size limit and the TTL.
+var buildScript = redis.NewScript(`
+local pending = redis.call('ZRANGE', KEYS[3], 0, -1, 'WITHSCORES')
+redis.call('DEL', KEYS[3])
+
+local generation = redis.call('GET', KEYS[2]) or ''
+if generation ~= ARGV[1] then
+	return 0
+end
+
+redis.call('DEL', KEYS[1])
+for i = 4, #ARGV, 2 do
+	redis.call('ZADD', KEYS[1], ARGV[i], ARGV[i + 1])
+end
+for i = 1, #pending, 2 do
+	redis.call('ZADD', KEYS[1], pending[i + 1], pending[i])
+end
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -tonumber(ARGV[2]) - 1)
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+return 1`)
+
+// Timelines reads and writes home feed timelines of the users
+type Timelines struct {
+	redis  *redis.Client
+	store  store.Store
+	logger *zap.SugaredLogger
+	cfg    *Config
+}
+
+func NewTimelines(redis *redis.Client, store store.Store, logger *zap.SugaredLogger, cfg *Config) *Timelines {
+	return &Timelines{
+		redis:  redis,
+		store:  store,
+		logger: logger,
+		cfg:    cfg,
+	}
+}
+
+// Read returns a page of the home feed of the user, the latest posts first.
+// The page is read from the database when the timeline hasn't been built
+// yet, the timeline is built in background then. Pages past the oldest post
+// of a full timeline are read from the database as well.
+func (t *Timelines) Read(ctx context.Context, userId int, page *filter.PageFilter) ([]model.Post, *filter.Cursor, error) {
+	key := timelineKey(userId)
+
+	exists, err := t.redis.Exists(key).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if exists == 0 {
+		go t.build(userId)
+		return t.store.Post().FindFeed(ctx, userId, page)
+	}
+
+	if err = t.redis.Expire(key, t.cfg.TimelineTTL).Err(); err != nil {
+		return nil, nil, err
+	}
+
+	entries, err := t.readEntries(key, page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The rest of the feed is older than the timeline keeps
+	if len(entries) <= page.Limit {
+		size, err := t.redis.ZCard(key).Result()
+		if err != nil {
+			return nil, nil, err
+		}
+		if size >= int64(t.cfg.TimelineSize) {
+			return t.store.Post().FindFeed(ctx, userId, page)
+		}
+	}
+
+	var next *filter.Cursor
+	if len(entries) > page.Limit {
+		entries = entries[:page.Limit]
+		last := entries[len(entries)-1]
+		next = &filter.Cursor{Time: last.PublishedAt, Id: last.PostId}
+	}
+
+	ids := make([]int, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.PostId
+	}
+
+	// Posts which have been deleted or unpublished are skipped
+	posts, err := t.store.Post().FindByIds(ctx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return posts, next, nil
+}
+
+// readEntries reads one entry more than the page limit after the cursor.
+// Posts which have the same score are sorted by their zero padded ids,
+// so the order is the same as in the database.
+func (t *Timelines) readEntries(key string, page *filter.PageFilter) ([]model.TimelineEntry, error) {
+	max := "+inf"
+	var ties int64
+
+	if page.After != nil {
+		max = formatScore(page.After.Time)
+
+		count, err := t.redis.ZCount(key, max, max).Result()
+		if err != nil {
+			return nil, err
+		}
+		ties = count
+	}
+
+	members, err := t.redis.ZRevRangeByScoreWithScores(key, &redis.ZRangeBy{
+		Max:   max,
+		Min:   "-inf",
+		Count: int64(page.Limit+1) + ties,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]model.TimelineEntry, 0, len(members))
+
+	for _, member := range members {
+		raw, _ := member.Member.(string)
+		postId, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeline member %q", raw)
+		}
+
+		entry := model.TimelineEntry{PostId: postId, PublishedAt: scoreTime(member.Score)}
+
+		// Posts up to the cursor with the same publishing time have been read
+		if page.After != nil && entry.PublishedAt.Equal(page.After.Time) && postId >= page.After.Id {
+			continue
+		}
+
+		entries = append(entries, entry)
+		if len(entries) > page.Limit {
+			break
+		}
+	}
+
+	return entries, nil
+}
+
+// FanOut adds the published post to the timelines of the followers of every
+// author of the post. Only timelines which have been built are changed.
+func (t *Timelines) FanOut(ctx context.Context, postId int, publishedAt time.Time) error {
+	followers, err := t.store.Follow().FindPostFollowers(ctx, postId)
+	if err != nil {
+		return err
+	}
+
+	score := formatScore(publishedAt)
+
+	for start := 0; start < len(followers); start += fanOutBatch {
+		end := start + fanOutBatch
+		if end > len(followers) {
+			end = len(followers)
+		}
+
+		_, err := t.redis.Pipelined(func(pipe redis.Pipeliner) error {
+			for _, followerId := range followers[start:end] {
+				keys := []string{timelineKey(followerId), buildLockKey(followerId), pendingKey(followerId)}
+				addScript.Eval(pipe, keys, score, member(postId), t.cfg.TimelineSize, int(buildTimeout.Seconds()))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Invalidate removes the timeline of the user when the followed
+// users change, so it is built again on the next read. The generation
+// of the timeline is changed, so a build which is running doesn't
+// write the timeline of the followed users it has read.
+func (t *Timelines) Invalidate(userId int) error {
+	_, err := t.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(timelineKey(userId))
+		pipe.Incr(generationKey(userId))
+		pipe.Expire(generationKey(userId), t.cfg.TimelineTTL)
+		return nil
+	})
+	return err
+}
+
+// build fills the timeline of the user from the database. Only one
+// build of the timeline runs at once, other reads use the database.
+func (t *Timelines) build(userId int) {
+	lockKey := buildLockKey(userId)
+
+	locked, err := t.redis.SetNX(lockKey, 1, buildTimeout).Result()
+	if err != nil || !locked {
+		return
+	}
+	defer t.redis.Del(lockKey)
+
+	// The generation is read before the database, so invalidations
+	// which happen after it are noticed when the timeline is written
+	generation, err := t.redis.Get(generationKey(userId)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		t.logger.Errorf("could not build timeline of user %d: %v", userId, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), buildTimeout)
+	defer cancel()
+
+	entries, err := t.store.Follow().FindTimeline(ctx, userId, t.cfg.TimelineSize)
+	if err != nil {
+		t.logger.Errorf("could not build timeline of user %d: %v", userId, err)
+		return
+	}
+
+	// Users who follow nobody have empty feeds which are cheap to read
+	if len(entries) == 0 {
+		return
+	}
+
+	args := make([]interface{}, 0, 3+2*len(entries))
+	args = append(args, generation, t.cfg.TimelineSize, int(t.cfg.TimelineTTL.Seconds()))
+	for _, entry := range entries {
+		args = append(args, formatScore(entry.PublishedAt), member(entry.PostId))
+	}
+
+	keys := []string{timelineKey(userId), generationKey(userId), pendingKey(userId)}
+	if err = buildScript.Run(t.redis, keys, args...).Err(); err != nil {
+		t.logger.Errorf("could not build timeline of user %d: %v", userId, err)
+	}
+}
+
+// timelineKey returns the Redis key of the timeline of the user
+func timelineKey(userId int) string {
+	return fmt.Sprintf("feed:%d", userId)
+}
+
+// generationKey returns the Redis key of the counter
+// of invalidations of the timeline of the user
+func generationKey(userId int) string {
+	return fmt.Sprintf("feed:%d:generation", userId)
+}
+
+// buildLockKey returns the Redis key which exists
+// while the timeline of the user is being built
+func buildLockKey(userId int) string {
+	return fmt.Sprintf("feed:%d:build", userId)
+}
+
+// pendingKey returns the Redis key of the posts which
+// have been published while the timeline was being built
+func pendingKey(userId int) string {
+	return fmt.Sprintf("feed:%d:pending", userId)
+}
+
+// member returns the zero padded post id, so posts published at the
+// same time are sorted lexicographically in the order of their ids
+func member(postId int) string {
+	return fmt.Sprintf("%012d", postId)
+}
+
+// formatScore returns the publishing time in microseconds, which is the
+// precision of the database, as the score. It fits float64 precisely.
+func formatScore(publishedAt time.Time) string {
+	return strconv.FormatInt(publishedAt.UnixNano()/int64(time.Microsecond), 10)
+}
+
+// scoreTime returns the publishing time of the score
+func scoreTime(score float64) time.Time {
+	return time.Unix(0, int64(score)*int64(time.Microsecond)).UTC()
+}
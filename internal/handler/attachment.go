@@ -0,0 +1,303 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/juicyluv/astral/internal/blob"
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/juicyluv/astral/internal/store"
+	"github.com/spf13/viper"
+)
+
+// attachmentFormField is the multipart form field of the uploaded file
+const attachmentFormField = "file"
+
+// attachmentTypes are sniffed content types of the files
+// which are allowed to be attached to posts
+var attachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// attachmentBlobKey returns the blob key of the content with the hash
+func attachmentBlobKey(hash string) string {
+	return "attachments/" + hash
+}
+
+// uploadAttachment will parse post id from URL and the file from multipart
+// form and attach it to the post. The content type is sniffed from the file,
+// files with the same content are stored once.
+func (h *Handler) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	postId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	post, err := h.store.Post().FindById(ctx, postId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token := contextGetToken(r)
+	if !canModifyPost(token, post) {
+		h.forbiddenResponse(w, r)
+		return
+	}
+
+	maxSize := viper.GetInt64("attachments.maxSize") << 20
+
+	data, fileName, err := readFormFile(w, r, attachmentFormField, maxSize)
+	if err != nil {
+		if errors.Is(err, errFileTooLarge) {
+			h.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the file must not be larger than %d MB", maxSize>>20))
+		} else {
+			h.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	// Parameters such as charset of text files are not kept
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil || !attachmentTypes[contentType] {
+		h.errorResponse(w, r, http.StatusUnsupportedMediaType, "the file must be a JPEG, PNG, GIF or WebP image, a PDF document or a plain text")
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	size := int64(len(data))
+
+	// The blob is touched before the content is stored,
+	// so the sweeper doesn't remove it during the upload
+	if err = h.store.Attachment().TouchBlob(ctx, hash, size, contentType); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	key := attachmentBlobKey(hash)
+
+	exists, err := h.blobs.Exists(ctx, key)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if !exists {
+		if err = h.blobs.Put(ctx, key, bytes.NewReader(data), contentType); err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	attachment := model.Attachment{
+		PostId:      postId,
+		UserId:      &token.UserId,
+		FileName:    model.SanitizeFileName(fileName),
+		ContentType: contentType,
+		Size:        size,
+		Hash:        hash,
+	}
+
+	quota := viper.GetInt64("attachments.userQuota") << 20
+
+	_, err = h.store.Attachment().Create(ctx, &attachment, quota)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrQuotaExceeded):
+			h.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the file exceeds your storage quota of %d MB", quota>>20))
+		case errors.Is(err, errNoRows):
+			h.recordNotFoundResponse(w, r)
+		default:
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = sendJSON(w, attachment, http.StatusCreated, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// listAttachments will parse post id from URL and send
+// the files attached to the post
+func (h *Handler) listAttachments(w http.ResponseWriter, r *http.Request) {
+	postId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	post, err := h.store.Post().FindById(ctx, postId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, _ := contextFindToken(r)
+	if !canViewPost(token, post) {
+		h.recordNotFoundResponse(w, r)
+		return
+	}
+
+	attachments, err := h.store.Attachment().FindPostAttachments(ctx, postId)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendJSON(w, jsonResponse{"attachments": attachments}, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// getAttachment will parse attachment id from URL and send the file.
+// Range requests are supported, images are shown inline and other
+// files are downloaded.
+func (h *Handler) getAttachment(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	attachment, ok := h.findAttachment(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	post, err := h.store.Post().FindById(ctx, attachment.PostId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.notFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, _ := contextFindToken(r)
+	if !canViewPost(token, post) {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	object, err := h.blobs.Get(ctx, attachmentBlobKey(attachment.Hash))
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			h.notFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer object.Content.Close()
+
+	disposition := "attachment"
+	if attachment.IsImage() {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", fmt.Sprintf("%q", attachment.Hash))
+
+	http.ServeContent(w, r, attachment.FileName, object.ModTime, object.Content)
+}
+
+// deleteAttachment will parse attachment id from URL and delete
+// the attachment. The content is removed by the sweeper when no
+// attachments refer to it anymore.
+func (h *Handler) deleteAttachment(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	attachment, ok := h.findAttachment(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	token := contextGetToken(r)
+
+	// Uploaders are allowed to delete their files,
+	// authors of the post are allowed to delete any
+	if attachment.UserId == nil || *attachment.UserId != token.UserId {
+		post, err := h.store.Post().FindById(ctx, attachment.PostId)
+		if err != nil {
+			if errors.Is(err, errNoRows) {
+				h.recordNotFoundResponse(w, r)
+			} else {
+				h.internalErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !canModifyPost(token, post) {
+			h.forbiddenResponse(w, r)
+			return
+		}
+	}
+
+	err := h.store.Attachment().Delete(ctx, attachment.Id)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = sendJSON(w, nil, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// findAttachment finds the attachment which id is in URL. It sends
+// the error response and returns false when it can't be found.
+func (h *Handler) findAttachment(ctx context.Context, w http.ResponseWriter, r *http.Request) (*model.Attachment, bool) {
+	attachmentId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	attachment, err := h.store.Attachment().FindById(ctx, attachmentId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return attachment, true
+}
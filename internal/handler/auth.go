@@ -5,17 +5,35 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/go-redis/redis/v7"
 	"github.com/gofrs/uuid"
 	"github.com/juicyluv/astral/internal/model"
 	"github.com/spf13/viper"
 )
 
+// Token types are put into the token_type claim, so a token
+// can't be used for another purpose than it has been issued for
+const (
+	tokenTypeAccess            = "access"
+	tokenTypeRefresh           = "refresh"
+	tokenTypeEmailConfirmation = "email_confirmation"
+	tokenTypeTwoFactor         = "two_factor_challenge"
+	tokenTypeMagicLink         = "magic_link"
+)
+
+// dummyUser is used to compare the password when there is no user
+// with given email, so the response time is the same as for a wrong password
+var dummyUser = func() model.User {
+	user := model.User{Password: "dummypassword"}
+	user.HashPassword()
+	return user
+}()
+
 // login parses user input, validates it and retrieves the user information
 // from database. Then it creates a new pair of tokens and returns it
 // to the client
@@ -28,34 +46,113 @@ func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	login.Email = strings.ToLower(login.Email)
+	ip := clientIp(r)
+
+	// Check whether signin is blocked after failed attempts
+	retryAfter, err := h.signinRetryAfter(login.Email, ip)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if retryAfter > 0 {
+		h.tooManyRequestsResponse(w, r, retryAfter)
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
 	defer cancel()
 
 	user, err := h.store.User().FindByEmail(ctx, login.Email)
+	if err != nil && !errors.Is(err, errNoRows) {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	// Unknown email and wrong password must look the same to the client,
+	// so the password is compared even if there is no such user
 	if err != nil {
-		if errors.Is(err, errNoRowsResponse) {
-			h.notFoundResponse(w, r)
-		} else {
+		dummyUser.ComparePassword(login.Password)
+		h.signinFailed(w, r, nil, login.Email)
+		return
+	}
+
+	if !user.ComparePassword(login.Password) {
+		h.signinFailed(w, r, user, login.Email)
+		return
+	}
+
+	if err = h.resetSigninFailures(login.Email); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	user.ClearPassword()
+
+	h.completeSignin(w, r, user)
+}
+
+// completeSignin is called when the user has proven the first factor.
+// It sends a new pair of tokens or the two-factor challenge if the user
+// has enabled two-factor authentication.
+func (h *Handler) completeSignin(w http.ResponseWriter, r *http.Request, user *model.User) {
+	// Tokens are issued only after the second factor is verified
+	if user.TotpEnabled {
+		challenge, err := h.createTwoFactorChallenge(user.Id)
+		if err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+
+		response := jsonResponse{
+			"twoFactorRequired": true,
+			"challengeToken":    challenge,
+		}
+
+		if err := sendJSON(w, response, http.StatusOK, nil); err != nil {
 			h.internalErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	if !user.ComparePassword(login.Password) || user.Email != login.Email {
-		h.badRequestResponse(w, r, errors.New("invalid email or password"))
+	h.sendTokenPair(w, r, user)
+}
+
+// signinFailed counts the failed signin attempt and notifies the account
+// owner if the account has been locked out. User is nil if there is no
+// user with the email.
+func (h *Handler) signinFailed(w http.ResponseWriter, r *http.Request, user *model.User, email string) {
+	lockedOut, err := h.registerSigninFailure(email, clientIp(r))
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
 		return
 	}
 
-	user.ClearPassword()
+	if lockedOut && user != nil {
+		h.sendTemplateEmail(user.Email, viper.GetString("mail.lockoutSubject"), "signin_lockout.html", struct {
+			Username    string
+			Ip          string
+			LockoutTime int
+		}{
+			Username:    user.Username,
+			Ip:          clientIp(r),
+			LockoutTime: viper.GetInt("auth.signinLockoutTime"),
+		})
+	}
+
+	h.invalidCredentialsResponse(w, r)
+}
 
-	tokens, err := h.createToken(user.Id)
+// sendTokenPair starts a new session of the user and
+// sends a new pair of tokens to the client
+func (h *Handler) sendTokenPair(w http.ResponseWriter, r *http.Request, user *model.User) {
+	tokens, err := h.createToken(user.Id, user.Role, "")
 	if err != nil {
 		h.internalErrorResponse(w, r, err)
 		return
 	}
 
-	err = h.saveTokenInformation(user.Id, tokens)
+	err = h.saveTokenInformation(r, user.Id, tokens, "")
 	if err != nil {
 		h.internalErrorResponse(w, r, err)
 		return
@@ -73,14 +170,10 @@ func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 
 // logout removes user session from cache and makes user tokens invalid
 func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
-	token, err := h.getTokenMetadata(r)
-	if err != nil {
-		h.unauthorizedResponse(w, r)
-		return
-	}
+	token := contextGetToken(r)
 
-	deleted, err := h.removeUserTokenFromCache(token.AccessUuid)
-	if err != nil || deleted == 0 {
+	err := h.revokeTokenFamily(token.FamilyId)
+	if err != nil {
 		h.internalErrorResponse(w, r, err)
 		return
 	}
@@ -88,27 +181,35 @@ func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// createToken creates the jwt token and returns an error if something
-// went wrong
-func (h *Handler) createToken(userId int) (*model.TokenDetails, error) {
-	// Access Token secret key
-	accessSecret := os.Getenv("JWT_SECRET")
-	if accessSecret == "" {
-		accessSecret = "supersecretkey"
-	}
+// jwks publishes public keys which are used to sign tokens,
+// so other services are able to verify them
+func (h *Handler) jwks(w http.ResponseWriter, r *http.Request) {
+	headers := http.Header{"Cache-Control": []string{"public, max-age=300"}}
 
-	// Refresh token secret key
-	refreshSecret := os.Getenv("JWT_REFRESH_SECRET")
-	if refreshSecret == "" {
-		refreshSecret = "supersecretkey"
+	if err := sendJSON(w, h.keys.JWKS(), http.StatusOK, headers); err != nil {
+		h.internalErrorResponse(w, r, err)
 	}
+}
 
+// createToken creates the jwt token and returns an error if something
+// went wrong. Tokens are issued within the given token family, if the
+// family id is empty, a new family is started.
+func (h *Handler) createToken(userId int, role string, familyId string) (*model.TokenDetails, error) {
 	// Access and Refresh token exp time
 	tokenExpTimeMinutes := time.Duration(viper.GetInt("auth.tokenExpTime"))
 	refreshExpTimeDays := time.Duration(viper.GetInt("auth.refreshExpTime"))
 
 	// Token details structure
-	td := model.TokenDetails{}
+	td := model.TokenDetails{FamilyId: familyId}
+
+	// Start a new token family
+	if td.FamilyId == "" {
+		family, err := uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+		td.FamilyId = family.String()
+	}
 
 	// Generate access token uuid and exp time
 	td.AtExpires = time.Now().Add(time.Minute * tokenExpTimeMinutes).Unix()
@@ -128,14 +229,16 @@ func (h *Handler) createToken(userId int) (*model.TokenDetails, error) {
 
 	// Access token payload
 	accessClaims := jwt.MapClaims{}
+	accessClaims["token_type"] = tokenTypeAccess
 	accessClaims["user_id"] = userId
 	accessClaims["access_uuid"] = td.AccessUuid
+	accessClaims["role"] = role
+	accessClaims["family_id"] = td.FamilyId
 	accessClaims["authorized"] = true
 	accessClaims["exp"] = td.AtExpires
 
 	// Generate Access token
-	at := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessToken, err := at.SignedString([]byte(accessSecret))
+	accessToken, err := h.keys.Sign(accessClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -143,14 +246,15 @@ func (h *Handler) createToken(userId int) (*model.TokenDetails, error) {
 
 	// Refresh token payload
 	refreshClaims := jwt.MapClaims{}
+	refreshClaims["token_type"] = tokenTypeRefresh
 	refreshClaims["user_id"] = userId
-	refreshClaims["refresh_uuid"] = td.AccessUuid
+	refreshClaims["refresh_uuid"] = td.RefreshUuid
+	refreshClaims["family_id"] = td.FamilyId
 	refreshClaims["authorized"] = true
 	refreshClaims["exp"] = td.RtExpires
 
 	// Generate Refresh token
-	rt := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshToken, err := rt.SignedString([]byte(refreshSecret))
+	refreshToken, err := h.keys.Sign(refreshClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -159,25 +263,118 @@ func (h *Handler) createToken(userId int) (*model.TokenDetails, error) {
 	return &td, nil
 }
 
-// saveTokenInformation saves token information in the cache
-func (h *Handler) saveTokenInformation(userId int, td *model.TokenDetails) error {
+// saveTokenInformation saves token information in the cache.
+// Parent is the refresh token uuid which was exchanged for the given
+// tokens, it is empty for the first pair of the token family.
+// The request is used to keep session metadata of the token family.
+func (h *Handler) saveTokenInformation(r *http.Request, userId int, td *model.TokenDetails, parent string) error {
 	// Converting Unix to UTC
 	at := time.Unix(td.AtExpires, 0)
 	rt := time.Unix(td.RtExpires, 0)
 	now := time.Now()
 
-	// Set Access token
-	err := h.redis.Set(td.AccessUuid, strconv.Itoa(userId), at.Sub(now)).Err()
+	familyKey := tokenFamilyKey(td.FamilyId)
+	lineageKey := tokenFamilyLineageKey(td.FamilyId)
+	userFamiliesKey := userTokenFamiliesKey(userId)
+
+	pipe := h.redis.TxPipeline()
+
+	// Set Access and Refresh tokens
+	pipe.Set(td.AccessUuid, strconv.Itoa(userId), at.Sub(now))
+	pipe.Set(td.RefreshUuid, strconv.Itoa(userId), rt.Sub(now))
+
+	// Remember the current pair of the family, the client which uses it
+	// and where it comes from
+	pipe.HSet(
+		familyKey,
+		"user_id", userId,
+		"access_uuid", td.AccessUuid,
+		"refresh_uuid", td.RefreshUuid,
+		"user_agent", r.UserAgent(),
+		"ip", clientIp(r),
+		"last_used", now.Unix(),
+	)
+	pipe.HSetNX(familyKey, "created_at", now.Unix())
+	pipe.HSet(lineageKey, td.RefreshUuid, parent)
+	pipe.Expire(familyKey, rt.Sub(now))
+	pipe.Expire(lineageKey, rt.Sub(now))
+
+	// Remember user token families to be able to revoke all of them at once
+	pipe.SAdd(userFamiliesKey, td.FamilyId)
+	pipe.Expire(userFamiliesKey, rt.Sub(now))
+
+	_, err := pipe.Exec()
+	return err
+}
+
+// tokenFamilyKey returns the cache key of the hash which
+// contains the current pair of tokens of the family
+func tokenFamilyKey(familyId string) string {
+	return "token_family:" + familyId
+}
+
+// tokenFamilyLineageKey returns the cache key of the hash which maps every
+// refresh token uuid issued within the family to its parent uuid
+func tokenFamilyLineageKey(familyId string) string {
+	return "token_family:" + familyId + ":lineage"
+}
+
+// userTokenFamiliesKey returns the cache key of the set which
+// contains every token family id of the user
+func userTokenFamiliesKey(userId int) string {
+	return fmt.Sprintf("user:%d:token_families", userId)
+}
+
+// revokeTokenFamily removes the current tokens of the family and
+// the family lineage from cache, so no token of the family can be used
+func (h *Handler) revokeTokenFamily(familyId string) error {
+	familyKey := tokenFamilyKey(familyId)
+
+	fields, err := h.redis.HMGet(familyKey, "access_uuid", "refresh_uuid", "user_id").Result()
 	if err != nil {
 		return err
 	}
 
-	// Set Refresh token
-	err = h.redis.Set(td.RefreshUuid, strconv.Itoa(userId), rt.Sub(now)).Err()
+	pipe := h.redis.TxPipeline()
+
+	keys := []string{familyKey, tokenFamilyLineageKey(familyId)}
+	for _, token := range fields[:2] {
+		if uuid, ok := token.(string); ok {
+			keys = append(keys, uuid)
+		}
+	}
+	pipe.Del(keys...)
+
+	if userId, ok := fields[2].(string); ok {
+		id, err := strconv.Atoi(userId)
+		if err != nil {
+			return err
+		}
+		pipe.SRem(userTokenFamiliesKey(id), familyId)
+	}
+
+	_, err = pipe.Exec()
+	return err
+}
+
+// revokeUserSessions revokes every token family of the user except
+// the one with keep id, so all other user sessions become invalid.
+// Pass an empty keep id to revoke all sessions.
+func (h *Handler) revokeUserSessions(userId int, keep string) error {
+	families, err := h.redis.SMembers(userTokenFamiliesKey(userId)).Result()
 	if err != nil {
 		return err
 	}
 
+	for _, familyId := range families {
+		if familyId == keep {
+			continue
+		}
+		if err = h.revokeTokenFamily(familyId); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -198,22 +395,15 @@ func (h *Handler) extractToken(r *http.Request) (string, error) {
 	return s[1], nil
 }
 
-// verifyToken checks whether token has HMAC encrypt algorithm
-// and parses the given token
+// verifyToken parses the token from Authorization header and checks
+// its signature with the key the token has been signed with
 func (h *Handler) verifyToken(r *http.Request) (*jwt.Token, error) {
 	tokenString, err := h.extractToken(r)
 	if err != nil {
 		return nil, err
 	}
 
-	// Check token signing method to be HMAC
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected token signing method")
-		}
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
-
+	token, err := h.keys.Parse(tokenString)
 	if err != nil {
 		return nil, err
 	}
@@ -221,18 +411,10 @@ func (h *Handler) verifyToken(r *http.Request) (*jwt.Token, error) {
 	return token, nil
 }
 
-// IsTokenExiped will check whether token valid or has already expired
-func (h *Handler) isTokenExpired(r *http.Request) error {
-	token, err := h.verifyToken(r)
-	if err != nil {
-		return err
-	}
-
-	if !token.Valid {
-		return err
-	}
-
-	return nil
+// hasTokenType reports whether the token has been issued for given purpose
+func hasTokenType(claims jwt.MapClaims, tokenType string) bool {
+	t, ok := claims["token_type"].(string)
+	return ok && t == tokenType
 }
 
 // getTokenMetadata will extract token metadata and return it if there is no error
@@ -245,7 +427,7 @@ func (h *Handler) getTokenMetadata(r *http.Request) (*model.TokenMetadata, error
 	err = errors.New("token is not valid")
 	claims, ok := token.Claims.(jwt.MapClaims)
 
-	if ok && token.Valid {
+	if ok && token.Valid && hasTokenType(claims, tokenTypeAccess) {
 		accessUuid, ok := claims["access_uuid"].(string)
 		if !ok {
 			return nil, err
@@ -256,9 +438,22 @@ func (h *Handler) getTokenMetadata(r *http.Request) (*model.TokenMetadata, error
 			return nil, err
 		}
 
+		familyId, ok := claims["family_id"].(string)
+		if !ok {
+			return nil, err
+		}
+
+		// Tokens issued before roles were introduced have no role claim
+		role, ok := claims["role"].(string)
+		if !ok {
+			role = model.RoleUser
+		}
+
 		return &model.TokenMetadata{
 			AccessUuid: accessUuid,
+			FamilyId:   familyId,
 			UserId:     int(userId),
+			Role:       role,
 		}, nil
 	}
 
@@ -302,15 +497,8 @@ func (h *Handler) refreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse token and make sure that it uses HMAC hash algorithm
-	token, err := jwt.Parse(tokenInput.RefreshToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-
-		return []byte(os.Getenv("JWT_REFRESH_SECRET")), nil
-	})
-
+	// Parse token and verify its signature
+	token, err := h.keys.Parse(tokenInput.RefreshToken)
 	if err != nil {
 		h.badRequestResponse(w, r, err)
 		return
@@ -324,13 +512,19 @@ func (h *Handler) refreshToken(w http.ResponseWriter, r *http.Request) {
 
 	// Get token claims and parse it
 	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid {
+	if ok && token.Valid && hasTokenType(claims, tokenTypeRefresh) {
 		errInvalidToken := errors.New("invalid token")
 
 		// Get refresh UUID and convert the interface to string
 		refreshUuid, ok := claims["refresh_uuid"].(string)
 		if !ok {
-			fmt.Println("uuid")
+			h.errorResponse(w, r, http.StatusUnprocessableEntity, errInvalidToken.Error())
+			return
+		}
+
+		// Get token family id
+		familyId, ok := claims["family_id"].(string)
+		if !ok {
 			h.errorResponse(w, r, http.StatusUnprocessableEntity, errInvalidToken.Error())
 			return
 		}
@@ -338,27 +532,77 @@ func (h *Handler) refreshToken(w http.ResponseWriter, r *http.Request) {
 		// Get user id from claims and convert it to int
 		userId, err := strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
 		if err != nil {
-			fmt.Println("user id")
 			h.errorResponse(w, r, http.StatusUnprocessableEntity, errInvalidToken.Error())
 			return
 		}
 
-		// Remove user token from cache
+		// Make sure the token has been issued within the family
+		// and the family has not been revoked
+		err = h.redis.HGet(tokenFamilyLineageKey(familyId), refreshUuid).Err()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				h.errorResponse(w, r, http.StatusUnprocessableEntity, errInvalidToken.Error())
+			} else {
+				h.internalErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// Remove user token from cache. If the token has already been removed,
+		// it has been rotated before and somebody replays it, so the whole
+		// token family is compromised.
 		deleted, err := h.removeUserTokenFromCache(refreshUuid)
-		if err != nil || deleted == 0 {
+		if err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+
+		if deleted == 0 {
+			h.logger.Warnw("security event: refresh token reuse detected, revoking token family",
+				"user_id", userId,
+				"family_id", familyId,
+				"refresh_uuid", refreshUuid,
+				"remote_addr", r.RemoteAddr,
+			)
+
+			if err = h.revokeTokenFamily(familyId); err != nil {
+				h.internalErrorResponse(w, r, err)
+				return
+			}
+
+			h.unauthorizedResponse(w, r)
+			return
+		}
+
+		// Access token of the rotated pair must not be used anymore
+		accessUuid, err := h.redis.HGet(tokenFamilyKey(familyId), "access_uuid").Result()
+		if err == nil {
+			_, err = h.removeUserTokenFromCache(accessUuid)
+		}
+		if err != nil && !errors.Is(err, redis.Nil) {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+		defer cancel()
+
+		// Get the user to issue tokens with the actual role
+		user, err := h.store.User().FindById(ctx, int(userId))
+		if err != nil {
 			h.errorResponse(w, r, http.StatusUnprocessableEntity, errInvalidToken.Error())
 			return
 		}
 
 		// Create a new pair of tokens
-		ts, err := h.createToken(int(userId))
+		ts, err := h.createToken(user.Id, user.Role, familyId)
 		if err != nil {
 			h.errorResponse(w, r, http.StatusForbidden, err.Error())
 			return
 		}
 
 		// Save new pair of tokens in cache
-		err = h.saveTokenInformation(int(userId), ts)
+		err = h.saveTokenInformation(r, user.Id, ts, refreshUuid)
 		if err != nil {
 			h.errorResponse(w, r, http.StatusForbidden, err.Error())
 			return
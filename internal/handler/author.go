@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/juicyluv/astral/internal/store"
+	"github.com/spf13/viper"
+)
+
+// inviteAuthor will parse post id from URL and the user id from request
+// body and invite the user to co-author the post. Only the owner of the
+// post can invite co-authors. The user becomes an author after accepting.
+func (h *Handler) inviteAuthor(w http.ResponseWriter, r *http.Request) {
+	postId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var input model.InviteAuthorDto
+
+	if err := readJSON(w, r, &input); err != nil {
+		h.invalidRequestBodyResponse(w, r)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	post, err := h.store.Post().FindById(ctx, postId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token := contextGetToken(r)
+	if !canManagePostAuthors(token, post) {
+		h.forbiddenResponse(w, r)
+		return
+	}
+
+	invited, err := h.store.User().FindById(ctx, input.UserId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.errorResponse(w, r, http.StatusBadRequest, "there is no user with this id")
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = h.store.Post().InviteAuthor(ctx, post.Id, invited.Id, token.UserId)
+	if err != nil {
+		if errors.Is(err, store.ErrAlreadyInvited) {
+			h.errorResponse(w, r, http.StatusConflict, "the user is already an author or has been invited")
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	h.sendTemplateEmail(invited.Email, viper.GetString("mail.coauthorInviteSubject"), "coauthor_invitation.html", struct {
+		Username  string
+		Inviter   string
+		PostId    int
+		PostTitle string
+	}{
+		Username:  invited.Username,
+		Inviter:   post.Author.Username,
+		PostId:    post.Id,
+		PostTitle: post.Title,
+	})
+
+	err = sendJSON(w, nil, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// acceptAuthorInvite will parse post id from URL and accept the invitation
+// of the authenticated user to co-author the post
+func (h *Handler) acceptAuthorInvite(w http.ResponseWriter, r *http.Request) {
+	postId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	err = h.store.Post().AcceptAuthor(ctx, postId, contextGetToken(r).UserId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.errorResponse(w, r, http.StatusNotFound, "there is no pending invitation to this post")
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = sendJSON(w, nil, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// removeAuthor will parse post id and user id from URL and remove the
+// co-author or the pending invitation of the user. The owner of the post
+// can remove anyone, co-authors can remove only themselves. The owner
+// can't be removed, ownership is changed by updating the post author.
+func (h *Handler) removeAuthor(w http.ResponseWriter, r *http.Request) {
+	postId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userId, err := readUserIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	post, err := h.store.Post().FindById(ctx, postId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token := contextGetToken(r)
+	if token.UserId != userId && !canManagePostAuthors(token, post) {
+		h.forbiddenResponse(w, r)
+		return
+	}
+
+	if userId == post.Author.Id {
+		h.errorResponse(w, r, http.StatusBadRequest, "the owner of the post can't be removed")
+		return
+	}
+
+	err = h.store.Post().RemoveAuthor(ctx, post.Id, userId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = sendJSON(w, nil, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
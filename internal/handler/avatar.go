@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/juicyluv/astral/internal/blob"
+	"github.com/juicyluv/astral/internal/imaging"
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/julienschmidt/httprouter"
+	"github.com/spf13/viper"
+)
+
+// avatarFormField is the multipart form field of the uploaded avatar
+const avatarFormField = "avatar"
+
+// avatarCacheControl lets clients and proxies cache avatars forever,
+// their URLs change when the content changes
+const avatarCacheControl = "public, max-age=31536000, immutable"
+
+// avatarTypes are content types of the images accepted as avatars
+var avatarTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// avatarFilePattern matches file names of the avatar thumbnails
+var avatarFilePattern = regexp.MustCompile(`^[0-9a-f]{64}_[0-9]+\.png$`)
+
+// uploadAvatar will parse user id from URL and the image from multipart
+// form and set it as the avatar of the user. Thumbnails of the image are
+// stored by the hash of the image, so the same images share them.
+func (h *Handler) uploadAvatar(w http.ResponseWriter, r *http.Request) {
+	userId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !canModifyUser(contextGetToken(r), userId) {
+		h.forbiddenResponse(w, r)
+		return
+	}
+
+	maxSize := viper.GetInt64("avatar.maxSize") << 20
+
+	data, _, err := readFormFile(w, r, avatarFormField, maxSize)
+	if err != nil {
+		if errors.Is(err, errFileTooLarge) {
+			h.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the avatar must not be larger than %d MB", maxSize>>20))
+		} else {
+			h.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	if !avatarTypes[http.DetectContentType(data)] {
+		h.errorResponse(w, r, http.StatusUnsupportedMediaType, "the avatar must be a JPEG, PNG or GIF image")
+		return
+	}
+
+	img, err := imaging.Decode(data)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	for _, size := range model.AvatarSizes {
+		key := "avatars/" + model.AvatarFileName(hash, size)
+
+		// Thumbnails of the same image have been stored already
+		exists, err := h.blobs.Exists(ctx, key)
+		if err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+		if exists {
+			continue
+		}
+
+		thumbnail, err := imaging.EncodePNG(imaging.Thumbnail(img, size))
+		if err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+
+		if err = h.blobs.Put(ctx, key, bytes.NewReader(thumbnail), "image/png"); err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = h.store.User().SetAvatar(ctx, userId, hash)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = sendJSON(w, jsonResponse{"avatar": model.NewAvatar(hash)}, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// getAvatar will parse the avatar file name from URL and send the
+// thumbnail. Avatars are content-addressed, so they are cached forever.
+func (h *Handler) getAvatar(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+	if !avatarFilePattern.MatchString(name) {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	object, err := h.blobs.Get(ctx, "avatars/"+name)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			h.notFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer object.Content.Close()
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", avatarCacheControl)
+	w.Header().Set("ETag", fmt.Sprintf("%q", name))
+
+	http.ServeContent(w, r, name, object.ModTime, object.Content)
+}
+
+// errFileTooLarge is returned when the uploaded file exceeds the size limit
+var errFileTooLarge = errors.New("the file is too large")
+
+// readFormFile reads the file and its name from the multipart form field. The
+// request body is limited, so large uploads are rejected before they are read.
+func readFormFile(w http.ResponseWriter, r *http.Request, field string, maxSize int64) ([]byte, string, error) {
+	// Multipart headers and boundaries take some space besides the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			return nil, "", errFileTooLarge
+		}
+		return nil, "", fmt.Errorf("the request must be a multipart form with the %s file", field)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, "", errFileTooLarge
+	}
+
+	return data, header.Filename, nil
+}
@@ -0,0 +1,293 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/juicyluv/astral/internal/handler/filter"
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/spf13/viper"
+)
+
+// listComments will parse post id from URL and return a page of the
+// post comments. Comments are returned as a flat list or as a tree
+// depending on the view argument.
+func (h *Handler) listComments(w http.ResponseWriter, r *http.Request) {
+	postId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	commentFilter, err := filter.NewCommentFilter(r.URL.Query())
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	post, err := h.store.Post().FindById(ctx, postId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, _ := contextFindToken(r)
+	if !canViewPost(token, post) {
+		h.recordNotFoundResponse(w, r)
+		return
+	}
+
+	comments, next, err := h.store.Comment().FindPostComments(ctx, postId, commentFilter)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if commentFilter.View != filter.CommentViewTree {
+		if err = h.setMyCommentReactions(ctx, r, commentPointers(comments)); err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+
+		if err = sendPage(w, r, "comments", comments, next.Encode()); err != nil {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	rootIds := make([]int, 0, len(comments))
+	for _, comment := range comments {
+		rootIds = append(rootIds, comment.Id)
+	}
+
+	replies, err := h.store.Comment().FindReplies(ctx, rootIds)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	tree := model.NewCommentTree(comments, replies)
+
+	if err = h.setMyCommentReactions(ctx, r, tree); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendPage(w, r, "comments", tree, next.Encode())
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// createComment will parse post id from URL and request body and
+// create a new comment on the post. The post author is notified
+// by email.
+func (h *Handler) createComment(w http.ResponseWriter, r *http.Request) {
+	postId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var input model.CreateCommentDto
+
+	if err := readJSON(w, r, &input); err != nil {
+		h.invalidRequestBodyResponse(w, r)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	post, err := h.store.Post().FindById(ctx, postId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token := contextGetToken(r)
+	if !canViewPost(token, post) {
+		h.recordNotFoundResponse(w, r)
+		return
+	}
+
+	// Replies must belong to the same post and can't be left on deleted comments
+	if input.ParentId != nil {
+		parent, err := h.store.Comment().FindById(ctx, *input.ParentId)
+		if err != nil && !errors.Is(err, errNoRows) {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+		if err != nil || parent.PostId != post.Id || parent.Deleted {
+			h.badRequestResponse(w, r, errors.New("there is no comment with this parent_id on the post"))
+			return
+		}
+	}
+
+	comment := model.Comment{
+		PostId:   post.Id,
+		ParentId: input.ParentId,
+		Author:   &model.User{Id: token.UserId},
+		Content:  input.Content,
+	}
+
+	commentId, err := h.store.Comment().Create(ctx, &comment)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if post.Author.Id != token.UserId {
+		if err = h.notifyPostAuthor(ctx, post, &comment); err != nil {
+			h.logError(err)
+		}
+	}
+
+	err = sendJSON(w, jsonResponse{"id": commentId}, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// updateComment will parse comment id from URL and request body
+// and update the comment content. Only the author can do that.
+func (h *Handler) updateComment(w http.ResponseWriter, r *http.Request) {
+	commentId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var input model.UpdateCommentDto
+
+	if err := readJSON(w, r, &input); err != nil {
+		h.invalidRequestBodyResponse(w, r)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	comment, err := h.store.Comment().FindById(ctx, commentId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !canModifyComment(contextGetToken(r), comment) {
+		h.forbiddenResponse(w, r)
+		return
+	}
+
+	err = h.store.Comment().Update(ctx, commentId, input.Content)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = sendJSON(w, nil, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// deleteComment will parse comment id from URL and delete the comment.
+// The comment is kept as a tombstone, so its replies keep the context.
+// Only the author can do that.
+func (h *Handler) deleteComment(w http.ResponseWriter, r *http.Request) {
+	commentId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	comment, err := h.store.Comment().FindById(ctx, commentId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !canModifyComment(contextGetToken(r), comment) {
+		h.forbiddenResponse(w, r)
+		return
+	}
+
+	err = h.store.Comment().Delete(ctx, commentId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = sendJSON(w, nil, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// notifyPostAuthor sends the email about the new comment to the post author
+func (h *Handler) notifyPostAuthor(ctx context.Context, post *model.Post, comment *model.Comment) error {
+	author, err := h.store.User().FindById(ctx, post.Author.Id)
+	if err != nil {
+		return err
+	}
+
+	commenter, err := h.store.User().FindById(ctx, comment.Author.Id)
+	if err != nil {
+		return err
+	}
+
+	h.sendTemplateEmail(author.Email, viper.GetString("mail.commentSubject"), "comment_notification.html", struct {
+		Username  string
+		Commenter string
+		PostTitle string
+		Comment   string
+	}{
+		Username:  author.Username,
+		Commenter: commenter.Username,
+		PostTitle: post.Title,
+		Comment:   comment.Content,
+	})
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/juicyluv/astral/internal/model"
+)
+
+// contextKey is used to store values in the request context
+// without colliding with keys from other packages.
+type contextKey string
+
+const tokenContextKey = contextKey("token")
+
+// contextSetToken returns a copy of the request with
+// the given token metadata added to its context.
+func contextSetToken(r *http.Request, token *model.TokenMetadata) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// contextFindToken retrieves the token metadata from the request context.
+// It reports false if the request is anonymous, so it can be called from
+// handlers wrapped with OptionalAuth.
+func contextFindToken(r *http.Request) (*model.TokenMetadata, bool) {
+	token, ok := r.Context().Value(tokenContextKey).(*model.TokenMetadata)
+	return token, ok
+}
+
+// contextGetToken retrieves the token metadata from the request context.
+// It should only be called from handlers wrapped with RequireAuth, so
+// a missing value is an unexpected error and we panic.
+func contextGetToken(r *http.Request) *model.TokenMetadata {
+	token, ok := r.Context().Value(tokenContextKey).(*model.TokenMetadata)
+	if !ok {
+		panic("missing token value in request context")
+	}
+	return token
+}
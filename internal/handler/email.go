@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/juicyluv/astral/internal/store"
+	"github.com/spf13/viper"
+)
+
+var errInvalidEmailChangeToken = errors.New("invalid or expired token")
+
+// changeEmail starts the email change of the authenticated user.
+// The email isn't changed until the user confirms the new address
+// with the link sent to it. The current address gets a notice with
+// the link which cancels the change.
+func (h *Handler) changeEmail(w http.ResponseWriter, r *http.Request) {
+	var input model.ChangeEmailDto
+
+	if err := readJSON(w, r, &input); err != nil {
+		h.invalidRequestBodyResponse(w, r)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	user, err := h.store.User().FindById(ctx, contextGetToken(r).UserId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	newEmail := strings.ToLower(input.Email)
+	if newEmail == strings.ToLower(user.Email) {
+		h.badRequestResponse(w, r, errors.New("the email is the same as the current one"))
+		return
+	}
+
+	_, err = h.store.User().FindByEmail(ctx, newEmail)
+	if err == nil {
+		h.badRequestResponse(w, r, store.ErrEmailTaken)
+		return
+	}
+	if !errors.Is(err, errNoRows) {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	confirmToken, err := generateRandomToken()
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	cancelToken, err := generateRandomToken()
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	expTime := time.Duration(viper.GetInt("mail.emailChangeExpTime")) * time.Hour
+
+	// A new request replaces the pending change, so previous links stop working
+	err = h.store.User().CreateEmailChange(ctx, &model.EmailChange{
+		UserId:          user.Id,
+		NewEmail:        newEmail,
+		TokenHash:       hashToken(confirmToken),
+		CancelTokenHash: hashToken(cancelToken),
+		ExpiresAt:       time.Now().Add(expTime),
+	})
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	h.sendTemplateEmail(newEmail, viper.GetString("mail.emailChangeSubject"), "email_change_confirm.html", struct {
+		Username    string
+		ConfirmLink string
+		ExpiresIn   int
+	}{
+		Username:    user.Username,
+		ConfirmLink: viper.GetString("mail.emailChangeConfirmUrl") + "?token=" + confirmToken,
+		ExpiresIn:   int(expTime.Hours()),
+	})
+
+	h.sendTemplateEmail(user.Email, viper.GetString("mail.emailChangeNoticeSubject"), "email_change_notice.html", struct {
+		Username   string
+		NewEmail   string
+		CancelLink string
+	}{
+		Username:   user.Username,
+		NewEmail:   newEmail,
+		CancelLink: viper.GetString("mail.emailChangeCancelUrl") + "?token=" + cancelToken,
+	})
+
+	message := jsonResponse{"message": "the confirmation link has been sent to the new email"}
+
+	if err = sendJSON(w, message, http.StatusOK, nil); err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// confirmEmailChange replaces the user email with the confirmed one
+// and marks it as verified
+func (h *Handler) confirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		h.badRequestResponse(w, r, errors.New("empty token"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	_, err := h.store.User().ConfirmEmailChange(ctx, hashToken(token))
+	if err != nil {
+		switch {
+		case errors.Is(err, errNoRows):
+			h.badRequestResponse(w, r, errInvalidEmailChangeToken)
+		case errors.Is(err, store.ErrEmailTaken):
+			h.badRequestResponse(w, r, err)
+		default:
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = sendJSON(w, nil, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// cancelEmailChange removes the pending email change. The link is
+// sent to the current address, so the owner can stop the change if
+// it wasn't requested by them.
+func (h *Handler) cancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		h.badRequestResponse(w, r, errors.New("empty token"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	err := h.store.User().CancelEmailChange(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.badRequestResponse(w, r, errInvalidEmailChangeToken)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = sendJSON(w, nil, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
@@ -3,11 +3,18 @@ package handler
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/jackc/pgx/v4"
 )
 
+// errCodeEmailNotVerified is sent to clients, so they can
+// tell users to verify the email
+const errCodeEmailNotVerified = "email_not_verified"
+
 var (
 	errNoRows         = pgx.ErrNoRows
 	errNoRowsResponse = errors.New("record not found")
@@ -67,3 +74,56 @@ func (h *Handler) invalidRequestBodyResponse(w http.ResponseWriter, r *http.Requ
 func (h *Handler) unauthorizedResponse(w http.ResponseWriter, r *http.Request) {
 	h.errorResponse(w, r, http.StatusUnauthorized, "you need to authorize to reach this resource")
 }
+
+// forbiddenResponse returns 403 Forbidden response
+func (h *Handler) forbiddenResponse(w http.ResponseWriter, r *http.Request) {
+	h.errorResponse(w, r, http.StatusForbidden, "you don't have permission to access this resource")
+}
+
+// insufficientScopeResponse returns 403 Forbidden response
+// when the personal access token lacks the required scope
+func (h *Handler) insufficientScopeResponse(w http.ResponseWriter, r *http.Request, scope string) {
+	h.errorResponse(w, r, http.StatusForbidden, fmt.Sprintf("the token requires %q scope to access this resource", scope))
+}
+
+// emailNotVerifiedResponse returns 403 Forbidden response with
+// email_not_verified code when the user hasn't verified the email
+func (h *Handler) emailNotVerifiedResponse(w http.ResponseWriter, r *http.Request) {
+	msg := jsonResponse{
+		"error": "you need to verify your email to reach this resource",
+		"code":  errCodeEmailNotVerified,
+	}
+
+	if err := sendJSON(w, msg, http.StatusForbidden, nil); err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// invalidCredentialsResponse returns 401 Unauthorized response when
+// signin fails. It is the same for unknown emails and wrong passwords.
+func (h *Handler) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	h.errorResponse(w, r, http.StatusUnauthorized, "invalid email or password")
+}
+
+// tooManyRequestsResponse returns 429 Too Many Requests response
+// with Retry-After header
+func (h *Handler) tooManyRequestsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	message := fmt.Sprintf("too many attempts, try again in %d seconds", seconds)
+	h.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// providerErrorResponse logs the error and returns 502 Bad Gateway
+// response when the external identity provider fails
+func (h *Handler) providerErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	h.logError(err)
+	h.errorResponse(w, r, http.StatusBadGateway, "could not sign in with the identity provider")
+}
+
+// preconditionFailedResponse returns 412 Precondition Failed response
+// when the resource has been changed since the version from If-Match
+func (h *Handler) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	h.errorResponse(w, r, http.StatusPreconditionFailed, "the resource has been modified, fetch it again and retry")
+}
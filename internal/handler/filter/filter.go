@@ -1,6 +1,378 @@
 package filter
 
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juicyluv/astral/internal/model"
+)
+
+// Page size limits of the post listings
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Post listings can be sorted by these fields
+const (
+	SortCreatedAt = "created_at"
+	SortUpdatedAt = "updated_at"
+	// SortPublishedAt is used by the home feed only,
+	// it can't be set by URL query arguments
+	SortPublishedAt = "published_at"
+)
+
+// Sort orders
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// Tag match modes. Posts must have any
+// or all of the tags in the filter.
+const (
+	TagMatchAny = "any"
+	TagMatchAll = "all"
+)
+
+// dateLayout is a layout of the date range arguments
+const dateLayout = "2006-01-02"
+
+var (
+	errInvalidCursor    = errors.New("invalid cursor")
+	errCursorSortChange = errors.New("cursor does not match sort and order of the listing")
+)
+
 // PostFilter is used to parse URL query arguments to filter posts
 type PostFilter struct {
-	Title string
+	// Title matches posts which title contains it, case-insensitive
+	Title    string
+	AuthorId int
+	// CreatedFrom and CreatedTo limit the creation date, both inclusive
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// Tags are slugs of the tags the posts must have, combined by TagMatch
+	Tags     []string
+	TagMatch string
+	// Status of the posts, posts which are not published
+	// are listed only for the author, who is the viewer
+	Status   string
+	ViewerId int
+	// FollowerId matches posts of the users the follower follows
+	FollowerId int
+	// Ids match posts with these ids only
+	Ids   []int
+	Sort  string
+	Order string
+	Limit int
+	// After is the cursor of the last post on the previous page
+	After *Cursor
+}
+
+// SearchFilter is used to parse URL query arguments to search posts.
+// Search results are ordered by rank, so they are paginated by offset.
+type SearchFilter struct {
+	// Query may contain "quoted phrases" and prefix* words
+	Query  string
+	Limit  int
+	Offset int
+}
+
+// PageFilter is used to parse URL query arguments of the
+// listings which are paginated by the cursor only
+type PageFilter struct {
+	Limit int
+	After *Cursor
+}
+
+// Comment listing views
+const (
+	CommentViewFlat = "flat"
+	CommentViewTree = "tree"
+)
+
+// CommentFilter is used to parse URL query arguments to list comments.
+// Comments are listed from the oldest. In the tree view pages contain
+// top level comments with every reply.
+type CommentFilter struct {
+	View  string
+	Limit int
+	After *Cursor
+}
+
+// Cursor points to a post in the sorted listing. It holds the value
+// of the sort field and the post id, which breaks ties. Cursors of post
+// listings hold the sort and the order as well, so they can't be used
+// with a listing which is sorted differently.
+type Cursor struct {
+	Time  time.Time
+	Id    int
+	Sort  string
+	Order string
+}
+
+// NewPostFilter parses post filter from URL query arguments.
+// It returns an error if any of the arguments is invalid.
+func NewPostFilter(query url.Values) (*PostFilter, error) {
+	f := PostFilter{
+		Title:    strings.TrimSpace(query.Get("title")),
+		TagMatch: TagMatchAny,
+		Sort:     SortCreatedAt,
+		Order:    OrderDesc,
+		Limit:    DefaultLimit,
+	}
+
+	// Tags can be passed as repeated or comma separated arguments
+	for _, v := range query["tag"] {
+		f.Tags = append(f.Tags, strings.Split(v, ",")...)
+	}
+	f.Tags = model.NormalizeTags(f.Tags)
+
+	if v := query.Get("tag_match"); v != "" {
+		if v != TagMatchAny && v != TagMatchAll {
+			return nil, fmt.Errorf("tag_match must be %s or %s", TagMatchAny, TagMatchAll)
+		}
+		f.TagMatch = v
+	}
+
+	if v := query.Get("author"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			return nil, errors.New("invalid author parameter")
+		}
+		f.AuthorId = id
+	}
+
+	if v := query.Get("created_from"); v != "" {
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			return nil, fmt.Errorf("created_from must be a date in %s format", dateLayout)
+		}
+		f.CreatedFrom = &t
+	}
+
+	if v := query.Get("created_to"); v != "" {
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			return nil, fmt.Errorf("created_to must be a date in %s format", dateLayout)
+		}
+		f.CreatedTo = &t
+	}
+
+	if f.CreatedFrom != nil && f.CreatedTo != nil && f.CreatedTo.Before(*f.CreatedFrom) {
+		return nil, errors.New("created_to must not be before created_from")
+	}
+
+	if v := query.Get("status"); v != "" {
+		if !isPostStatus(v) {
+			return nil, errors.New("invalid status parameter")
+		}
+		f.Status = v
+	}
+
+	if v := query.Get("sort"); v != "" {
+		if v != SortCreatedAt && v != SortUpdatedAt {
+			return nil, fmt.Errorf("sort must be %s or %s", SortCreatedAt, SortUpdatedAt)
+		}
+		f.Sort = v
+	}
+
+	if v := query.Get("order"); v != "" {
+		if v != OrderAsc && v != OrderDesc {
+			return nil, fmt.Errorf("order must be %s or %s", OrderAsc, OrderDesc)
+		}
+		f.Order = v
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		f.Limit = limit
+	}
+
+	if v := query.Get("after"); v != "" {
+		cursor, err := DecodeCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Sort != f.Sort || cursor.Order != f.Order {
+			return nil, errCursorSortChange
+		}
+		f.After = cursor
+	}
+
+	return &f, nil
+}
+
+// NewSearchFilter parses search filter from URL query arguments.
+// It returns an error if any of the arguments is invalid.
+func NewSearchFilter(query url.Values) (*SearchFilter, error) {
+	f := SearchFilter{
+		Query: strings.TrimSpace(query.Get("q")),
+		Limit: DefaultLimit,
+	}
+
+	if f.Query == "" {
+		return nil, errors.New("empty search query")
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		f.Limit = limit
+	}
+
+	if v := query.Get("after"); v != "" {
+		offset, err := DecodeOffset(v)
+		if err != nil {
+			return nil, err
+		}
+		f.Offset = offset
+	}
+
+	return &f, nil
+}
+
+// NewPageFilter parses page filter from URL query arguments.
+// It returns an error if any of the arguments is invalid.
+func NewPageFilter(query url.Values) (*PageFilter, error) {
+	f := PageFilter{
+		Limit: DefaultLimit,
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		f.Limit = limit
+	}
+
+	if v := query.Get("after"); v != "" {
+		cursor, err := DecodeCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		f.After = cursor
+	}
+
+	return &f, nil
+}
+
+// NewCommentFilter parses comment filter from URL query arguments.
+// It returns an error if any of the arguments is invalid.
+func NewCommentFilter(query url.Values) (*CommentFilter, error) {
+	f := CommentFilter{
+		View:  CommentViewFlat,
+		Limit: DefaultLimit,
+	}
+
+	if v := query.Get("view"); v != "" {
+		if v != CommentViewFlat && v != CommentViewTree {
+			return nil, fmt.Errorf("view must be %s or %s", CommentViewFlat, CommentViewTree)
+		}
+		f.View = v
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		f.Limit = limit
+	}
+
+	if v := query.Get("after"); v != "" {
+		cursor, err := DecodeCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		f.After = cursor
+	}
+
+	return &f, nil
+}
+
+// Encode returns the opaque string representation of the cursor.
+// It returns an empty string for nil cursor.
+func (c *Cursor) Encode() string {
+	if c == nil {
+		return ""
+	}
+
+	raw := fmt.Sprintf("%d:%d", c.Time.UnixNano(), c.Id)
+	if c.Sort != "" {
+		raw += ":" + c.Sort + ":" + c.Order
+	}
+
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses the cursor returned by Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 2 && len(parts) != 4 {
+		return nil, errInvalidCursor
+	}
+
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil || id < 1 {
+		return nil, errInvalidCursor
+	}
+
+	cursor := Cursor{Time: time.Unix(0, nanos).UTC(), Id: id}
+	if len(parts) == 4 {
+		cursor.Sort, cursor.Order = parts[2], parts[3]
+	}
+
+	return &cursor, nil
+}
+
+// EncodeOffset returns the opaque cursor of the search results page
+func EncodeOffset(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+// DecodeOffset parses the cursor returned by EncodeOffset
+func DecodeOffset(s string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || !strings.HasPrefix(string(raw), "offset:") {
+		return 0, errInvalidCursor
+	}
+
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(raw), "offset:"))
+	if err != nil || offset < 0 {
+		return 0, errInvalidCursor
+	}
+
+	return offset, nil
+}
+
+// isPostStatus reports whether the status is a known post status
+func isPostStatus(status string) bool {
+	for _, s := range model.PostStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
 }
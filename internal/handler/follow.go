@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/juicyluv/astral/internal/handler/filter"
+	"github.com/juicyluv/astral/internal/model"
+)
+
+// followUser will parse user id from URL and make the
+// authenticated user follow the user with this id
+func (h *Handler) followUser(w http.ResponseWriter, r *http.Request) {
+	h.changeFollow(w, r, func(ctx context.Context, followerId, followeeId int) error {
+		if _, err := h.store.User().FindById(ctx, followeeId); err != nil {
+			return err
+		}
+		return h.store.Follow().Follow(ctx, followerId, followeeId)
+	})
+}
+
+// unfollowUser will parse user id from URL and make the
+// authenticated user stop following the user with this id
+func (h *Handler) unfollowUser(w http.ResponseWriter, r *http.Request) {
+	h.changeFollow(w, r, func(ctx context.Context, followerId, followeeId int) error {
+		return h.store.Follow().Unfollow(ctx, followerId, followeeId)
+	})
+}
+
+// changeFollow parses the user id from URL and applies the follow
+// change of the authenticated user. The home feed of the user is
+// built again, since the followed users have changed.
+func (h *Handler) changeFollow(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, followerId, followeeId int) error) {
+	followeeId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	token := contextGetToken(r)
+	if token.UserId == followeeId {
+		h.errorResponse(w, r, http.StatusBadRequest, "you can't follow yourself")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	if err = change(ctx, token.UserId, followeeId); err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err = h.feed.Invalidate(token.UserId); err != nil {
+		h.logError(err)
+	}
+
+	err = sendJSON(w, nil, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// listFollowers will parse user id from URL and return
+// a page of the followers of the user, the latest first
+func (h *Handler) listFollowers(w http.ResponseWriter, r *http.Request) {
+	h.listFollows(w, r, h.store.Follow().FindFollowers)
+}
+
+// listFollowing will parse user id from URL and return a page
+// of the users the user follows, the latest followed first
+func (h *Handler) listFollowing(w http.ResponseWriter, r *http.Request) {
+	h.listFollows(w, r, h.store.Follow().FindFollowing)
+}
+
+// listFollows sends the page of the follows found by the find function
+func (h *Handler) listFollows(w http.ResponseWriter, r *http.Request, find func(context.Context, int, *filter.PageFilter) ([]model.Follow, *filter.Cursor, error)) {
+	userId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := filter.NewPageFilter(r.URL.Query())
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	if _, err = h.store.User().FindById(ctx, userId); err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	follows, next, err := find(ctx, userId, page)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendPage(w, r, "users", follows, next.Encode())
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// getFeed returns a page of the home feed of the authenticated user.
+// The feed contains published posts of the users the user follows,
+// the latest first.
+func (h *Handler) getFeed(w http.ResponseWriter, r *http.Request) {
+	page, err := filter.NewPageFilter(r.URL.Query())
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	posts, next, err := h.feed.Read(ctx, contextGetToken(r).UserId, page)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if err = h.setMyPostReactions(ctx, r, postPointers(posts)); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendPage(w, r, "posts", posts, next.Encode())
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
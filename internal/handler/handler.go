@@ -4,7 +4,12 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v7"
+	"github.com/juicyluv/astral/internal/blob"
+	"github.com/juicyluv/astral/internal/feed"
+	"github.com/juicyluv/astral/internal/keys"
+	"github.com/juicyluv/astral/internal/oidc"
 	"github.com/juicyluv/astral/internal/queue"
+	"github.com/juicyluv/astral/internal/secretbox"
 	"github.com/juicyluv/astral/internal/store"
 	"github.com/julienschmidt/httprouter"
 	"github.com/spf13/viper"
@@ -18,6 +23,12 @@ type Handler struct {
 	redis  *redis.Client
 	store  store.Store
 	queue  *queue.Queue
+	keys   *keys.KeyManager
+	box    *secretbox.Box
+	feed   *feed.Timelines
+	blobs  blob.Store
+
+	providers map[string]*oidc.Provider
 
 	requestTimeout time.Duration
 }
@@ -26,13 +37,19 @@ type Handler struct {
 type jsonResponse map[string]interface{}
 
 // NewHandler will return a pointer to the Handler instance
-func NewHandler(logger *zap.SugaredLogger, store store.Store, redis *redis.Client, queue *queue.Queue) *Handler {
+func NewHandler(logger *zap.SugaredLogger, store store.Store, redis *redis.Client, queue *queue.Queue, keys *keys.KeyManager, box *secretbox.Box, timelines *feed.Timelines, blobs blob.Store, providers map[string]*oidc.Provider) *Handler {
 	h := &Handler{
 		router: httprouter.New(),
 		logger: logger,
 		store:  store,
 		redis:  redis,
 		queue:  queue,
+		keys:   keys,
+		box:    box,
+		feed:   timelines,
+		blobs:  blobs,
+
+		providers: providers,
 
 		requestTimeout: time.Duration(viper.GetInt("http.requestTimeout")) * time.Second,
 	}
@@ -1,11 +1,17 @@
 package handler
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -37,6 +43,25 @@ func sendJSON(w http.ResponseWriter, data interface{}, statusCode int, headers h
 	return nil
 }
 
+// sendPage sends a page of the listing with the cursor of the next page.
+// The link to the next page is also set in the Link header. Empty cursor
+// means there are no more pages.
+func sendPage(w http.ResponseWriter, r *http.Request, key string, items interface{}, next string) error {
+	response := jsonResponse{key: items, "next_cursor": nil}
+	headers := make(http.Header)
+
+	if next != "" {
+		response["next_cursor"] = next
+
+		query := r.URL.Query()
+		query.Set("after", next)
+		link := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		headers.Set("Link", fmt.Sprintf(`<%s>; rel="next"`, link.String()))
+	}
+
+	return sendJSON(w, response, http.StatusOK, headers)
+}
+
 // readJSON decodes request body to the given destination(usually model struct)
 // and if an error occurred returns specific error message.
 func readJSON(w http.ResponseWriter, r *http.Request, dest interface{}) error {
@@ -108,7 +133,82 @@ func readIdParam(r *http.Request) (int, error) {
 	return int(id), nil
 }
 
+// readUserIdParam returns a user id of the URL path on success.
+func readUserIdParam(r *http.Request) (int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("user_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid user id parameter")
+	}
+	return int(id), nil
+}
+
+// readRevisionParam returns a post revision number of the URL path on success.
+func readRevisionParam(r *http.Request) (int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	revision, err := strconv.ParseInt(params.ByName("rev"), 10, 64)
+	if err != nil || revision < 1 {
+		return 0, errors.New("invalid revision parameter")
+	}
+	return int(revision), nil
+}
+
+// versionHeaders returns headers with the version of the resource as ETag
+func versionHeaders(version int) http.Header {
+	headers := make(http.Header)
+	headers.Set("ETag", fmt.Sprintf("%q", strconv.Itoa(version)))
+	return headers
+}
+
+// readIfMatch returns the version of the resource from If-Match header.
+// The version is nil when the header is missing or is "*", so the resource
+// is updated regardless of its version. It returns false when the header
+// can't match any version, like weak or malformed entity tags.
+func readIfMatch(r *http.Request) (*int, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil, true
+	}
+
+	if len(header) < 2 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		return nil, false
+	}
+
+	version, err := strconv.Atoi(header[1 : len(header)-1])
+	if err != nil {
+		return nil, false
+	}
+
+	return &version, true
+}
+
+// clientIp returns the IP address of the client which sent the request.
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 // logError logs an error to logger output.
 func (h *Handler) logError(err error) {
 	h.logger.Error(err)
 }
+
+// generateRandomToken returns a cryptographically secure
+// random token encoded to be safe for using in URLs.
+func generateRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns SHA-256 hash of the token in hex format.
+// It is used to not keep plain tokens in the storage.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
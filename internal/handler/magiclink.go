@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/spf13/viper"
+)
+
+// magicLinkCookie is the cookie which binds the magic link
+// to the device that has requested it
+const magicLinkCookie = "magic_link_nonce"
+
+// requestMagicLink sends the single-use signin link to the user email.
+// The link can be used only on the same device, since it is bound to
+// the nonce which is set as a cookie. Like forgotPassword, it responds
+// with the same message whether the email is registered or not.
+func (h *Handler) requestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var input model.MagicLinkDto
+
+	if err := readJSON(w, r, &input); err != nil {
+		h.invalidRequestBodyResponse(w, r)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	user, err := h.store.User().FindByEmail(ctx, strings.ToLower(input.Email))
+	if err != nil && !errors.Is(err, errNoRows) {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+	found := err == nil
+
+	// The nonce is set for unknown emails as well, so the responses
+	// don't differ
+	nonce, err := generateRandomToken()
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	expTime := time.Duration(viper.GetInt("auth.magicLinkExpTime")) * time.Minute
+
+	if found {
+		linkId, err := generateRandomToken()
+		if err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+
+		token, err := h.createEmailToken(user.Id, tokenTypeMagicLink, expTime, jwt.MapClaims{
+			"link_id":    linkId,
+			"nonce_hash": hashToken(nonce),
+		})
+		if err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+
+		err = h.redis.Set(magicLinkKey(linkId), strconv.Itoa(user.Id), expTime).Err()
+		if err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+
+		h.sendTemplateEmail(user.Email, viper.GetString("mail.magicLinkSubject"), "magic_link.html", struct {
+			Username   string
+			SigninLink string
+			ExpiresIn  int
+		}{
+			Username:   user.Username,
+			SigninLink: viper.GetString("mail.magicLinkUrl") + "?token=" + token,
+			ExpiresIn:  int(expTime.Minutes()),
+		})
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkCookie,
+		Value:    nonce,
+		Path:     "/api/auth/magic-link",
+		MaxAge:   int(expTime.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	message := jsonResponse{"message": "if the email is registered, the signin link has been sent to it"}
+
+	if err := sendJSON(w, message, http.StatusOK, nil); err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// magicLinkCallback verifies the magic link token and the device nonce,
+// consumes the link and signs the user in.
+func (h *Handler) magicLinkCallback(w http.ResponseWriter, r *http.Request) {
+	tokenString := r.URL.Query().Get("token")
+	if tokenString == "" {
+		h.badRequestResponse(w, r, errors.New("empty token"))
+		return
+	}
+
+	claims, userId, err := h.parseEmailToken(tokenString, tokenTypeMagicLink)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	linkId, _ := claims["link_id"].(string)
+	nonceHash, _ := claims["nonce_hash"].(string)
+	if linkId == "" || nonceHash == "" {
+		h.badRequestResponse(w, r, errInvalidEmailToken)
+		return
+	}
+
+	// The link must be opened on the device which has requested it
+	cookie, err := r.Cookie(magicLinkCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(hashToken(cookie.Value)), []byte(nonceHash)) != 1 {
+		h.errorResponse(w, r, http.StatusForbidden, "the signin link must be opened on the device it was requested from")
+		return
+	}
+
+	// Only the request which actually deleted the link may use it
+	deleted, err := h.redis.Del(magicLinkKey(linkId)).Result()
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+	if deleted == 0 {
+		h.badRequestResponse(w, r, errors.New("the signin link has expired or has already been used"))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkCookie,
+		Path:     "/api/auth/magic-link",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	user, err := h.store.User().FindById(ctx, userId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.ClearPassword()
+	h.completeSignin(w, r, user)
+}
+
+// magicLinkKey returns the cache key of the magic link
+func magicLinkKey(linkId string) string {
+	return "magic_link:" + linkId
+}
@@ -1,18 +1,22 @@
 package handler
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/juicyluv/astral/internal/mail"
+	"github.com/juicyluv/astral/internal/model"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
@@ -21,8 +25,6 @@ import (
 // then parses token to verify if it expired and get user id,
 // and then updates the user as verified
 func (h *Handler) confirmEmail(w http.ResponseWriter, r *http.Request) {
-	errInvalidToken := errors.New("invalid token")
-
 	// Parse token from URL query
 	tokenString := r.URL.Query().Get("token")
 	if tokenString == "" {
@@ -31,41 +33,22 @@ func (h *Handler) confirmEmail(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse and check token, get metadata
-	// Check token signing method to be HMAC
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected token signing method")
-		}
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
-
-	if err != nil {
-		h.badRequestResponse(w, r, errInvalidToken)
-		return
-	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		h.badRequestResponse(w, r, errInvalidToken)
-		return
-	}
-
-	userId, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+	_, userId, err := h.parseEmailToken(tokenString, tokenTypeEmailConfirmation)
 	if err != nil {
-		h.badRequestResponse(w, r, errInvalidToken)
+		h.badRequestResponse(w, r, err)
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
 	defer cancel()
 
-	err = h.store.User().ConfirmEmail(ctx, int(userId))
+	err = h.store.User().ConfirmEmail(ctx, userId)
 	if err != nil {
 		h.internalErrorResponse(w, r, err)
 		return
 	}
 
-	user, err := h.store.User().FindById(ctx, int(userId))
+	user, err := h.store.User().FindById(ctx, userId)
 	if err != nil {
 		h.internalErrorResponse(w, r, err)
 		return
@@ -91,18 +74,167 @@ func (h *Handler) confirmEmail(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *Handler) createEmailToken(userId int) (string, error) {
-	tokenExpTimeDays := time.Duration(viper.GetInt("mail.tokenExpTime")) * time.Hour * 24
+// resendConfirmation sends a new confirmation link to the user
+// which email is not verified yet. It is rate limited per account.
+func (h *Handler) resendConfirmation(w http.ResponseWriter, r *http.Request) {
+	token := contextGetToken(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	user, err := h.store.User().FindById(ctx, token.UserId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.IsVerified {
+		h.errorResponse(w, r, http.StatusConflict, "the email is already verified")
+		return
+	}
+
+	// Only one link may be sent per interval
+	interval := time.Duration(viper.GetInt("mail.resendInterval")) * time.Second
+	key := verificationResendKey(user.Id)
+
+	ok, err := h.redis.SetNX(key, 1, interval).Result()
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		retryAfter, err := h.redis.TTL(key).Result()
+		if err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+		h.tooManyRequestsResponse(w, r, retryAfter)
+		return
+	}
+
+	if err = h.sendConfirmationEmail(user); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	message := jsonResponse{"message": "the confirmation link has been sent to your email"}
+
+	if err = sendJSON(w, message, http.StatusOK, nil); err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
 
+// verificationResendKey returns the cache key which
+// limits confirmation emails sent to the user
+func verificationResendKey(userId int) string {
+	return fmt.Sprintf("user:%d:verification_resend", userId)
+}
+
+// sendConfirmationEmail creates the email confirmation token
+// and sends the confirmation link to the user
+func (h *Handler) sendConfirmationEmail(user *model.User) error {
+	expTime := time.Duration(viper.GetInt("mail.tokenExpTime")) * time.Hour * 24
+
+	token, err := h.createEmailToken(user.Id, tokenTypeEmailConfirmation, expTime, nil)
+	if err != nil {
+		return err
+	}
+
+	h.sendTemplateEmail(user.Email, viper.GetString("mail.subject"), "confirm_request.html", struct {
+		Username    string
+		ConfirmLink string
+	}{
+		Username:    user.Username,
+		ConfirmLink: viper.GetString("mail.confirmUrl") + "?token=" + token,
+	})
+
+	return nil
+}
+
+// mailTemplatesDir is a directory which contains email html templates
+const mailTemplatesDir = "./internal/mail/templates"
+
+// sendTemplateEmail renders the email template with given data and
+// dispatches the message to the mail queue in the background.
+func (h *Handler) sendTemplateEmail(email, subject, templateName string, data interface{}) {
+	go func(logger *zap.SugaredLogger) {
+		t, err := template.ParseFiles(filepath.Join(mailTemplatesDir, templateName))
+		if err != nil {
+			logger.Errorf("cannot parse email template %s: %v", templateName, err)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err = t.ExecuteTemplate(&buf, templateName, data); err != nil {
+			logger.Errorf("cannot execute email template %s: %v", templateName, err)
+			return
+		}
+
+		var messageBuffer bytes.Buffer
+		encoder := json.NewEncoder(&messageBuffer)
+		err = encoder.Encode(mail.Message{
+			EmailTo: email,
+			Subject: subject,
+			Mime:    mail.MimeHTML,
+			Message: buf.Bytes(),
+		})
+		if err != nil {
+			logger.Error(err)
+			return
+		}
+
+		err = h.queue.Dispatch(messageBuffer.Bytes())
+		if err != nil {
+			logger.Errorf("could not send message to the queue: %v", err)
+			return
+		}
+
+		logger.Infof("email was sent to %s", email)
+	}(h.logger)
+}
+
+var errInvalidEmailToken = errors.New("invalid token")
+
+// createEmailToken creates a signed token of given type which is sent
+// to the user by email. Extra claims are added to the token as is.
+func (h *Handler) createEmailToken(userId int, tokenType string, expTime time.Duration, extra jwt.MapClaims) (string, error) {
 	claims := jwt.MapClaims{}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	claims["token_type"] = tokenType
 	claims["user_id"] = userId
-	claims["exp"] = tokenExpTimeDays
+	claims["exp"] = time.Now().Add(expTime).Unix()
 
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	token, err := t.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	token, err := h.keys.Sign(claims)
 	if err != nil {
 		return "", err
 	}
 
 	return token, nil
 }
+
+// parseEmailToken verifies the token sent by email and checks its type.
+// It returns the token claims and the user id the token was issued for.
+func (h *Handler) parseEmailToken(tokenString, tokenType string) (jwt.MapClaims, int, error) {
+	token, err := h.keys.Parse(tokenString)
+	if err != nil {
+		return nil, 0, errInvalidEmailToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || !hasTokenType(claims, tokenType) {
+		return nil, 0, errInvalidEmailToken
+	}
+
+	userId, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+	if err != nil {
+		return nil, 0, errInvalidEmailToken
+	}
+
+	return claims, int(userId), nil
+}
@@ -1,16 +1,121 @@
 package handler
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
 
-// RequireAuth middleware will check if token is presented and if it is valid
+// RequireAuth middleware will check if token is presented and if it is valid.
+// Both session tokens and personal access tokens are accepted.
+// Token metadata is stored in the request context, so handlers can get it
+// with contextGetToken.
 func (h *Handler) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := h.isTokenExpired(r)
+		tokenString, err := h.extractToken(r)
 		if err != nil {
 			h.unauthorizedResponse(w, r)
 			return
 		}
 
+		if strings.HasPrefix(tokenString, personalTokenPrefix) {
+			token, err := h.authenticatePersonalToken(tokenString)
+			if err != nil {
+				h.unauthorizedResponse(w, r)
+				return
+			}
+
+			next(w, contextSetToken(r, token))
+			return
+		}
+
+		token, err := h.getTokenMetadata(r)
+		if err != nil {
+			h.unauthorizedResponse(w, r)
+			return
+		}
+
+		// Make sure the session has not been closed
+		userId, err := h.fetchTokenDataFromRedis(token)
+		if err != nil || userId != token.UserId {
+			h.unauthorizedResponse(w, r)
+			return
+		}
+
+		if err = h.touchSession(token.FamilyId); err != nil {
+			h.logError(err)
+		}
+
+		next(w, contextSetToken(r, token))
+	}
+}
+
+// OptionalAuth middleware will authenticate the request like RequireAuth
+// if it has Authorization header and pass anonymous requests as is.
+// Handlers get the token with contextFindToken.
+func (h *Handler) OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	authenticated := h.RequireAuth(next)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next(w, r)
+			return
+		}
+
+		authenticated(w, r)
+	}
+}
+
+// RequireScope middleware will check whether the authenticated token
+// has been granted the scope. It must be wrapped with RequireAuth.
+func (h *Handler) RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !contextGetToken(r).HasScope(scope) {
+			h.insufficientScopeResponse(w, r, scope)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// RequireSession middleware will reject personal access tokens. It protects
+// routes which manage credentials, so a leaked personal access token can't
+// be used to take over the account. It must be wrapped with RequireAuth.
+func (h *Handler) RequireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if contextGetToken(r).IsPersonalToken() {
+			h.errorResponse(w, r, http.StatusForbidden, "personal access tokens are not allowed to reach this resource")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// RequireVerified middleware will reject users which haven't verified
+// their email yet. It must be wrapped with RequireAuth.
+func (h *Handler) RequireVerified(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+		defer cancel()
+
+		user, err := h.store.User().FindById(ctx, contextGetToken(r).UserId)
+		if err != nil {
+			if errors.Is(err, errNoRows) {
+				h.unauthorizedResponse(w, r)
+			} else {
+				h.internalErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !user.IsVerified {
+			h.emailNotVerifiedResponse(w, r)
+			return
+		}
+
 		next(w, r)
 	}
 }
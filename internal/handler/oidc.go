@@ -0,0 +1,319 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/juicyluv/astral/internal/oidc"
+	"github.com/julienschmidt/httprouter"
+	"github.com/spf13/viper"
+)
+
+var (
+	errInvalidOidcState = errors.New("invalid or expired state")
+	errUnverifiedEmail  = errors.New("the provider has not verified your email")
+	// errUnverifiedAccount is returned when the local account with the email
+	// has not been verified, so it may have been registered by someone else
+	errUnverifiedAccount = errors.New("an unverified account uses your email, reset its password and verify the email before signing in with the provider")
+)
+
+// oidcStateCookie is the cookie which binds the callback to the browser
+// which has started the signin, so an attacker can't sign the victim in
+// to their own account with a callback URL of their flow
+const oidcStateCookie = "oidc_state"
+
+// oidcState is kept in cache between the redirect to the provider
+// and the callback. It binds the callback to the request which started
+// the signin.
+type oidcState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// oidcLogin starts the authorization code flow with the provider from URL.
+// It redirects the client to the provider consent page.
+func (h *Handler) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.readProviderParam(r)
+	if !ok {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	state := oidcState{Provider: provider.Name}
+
+	stateId, err := generateRandomToken()
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if state.Nonce, err = generateRandomToken(); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if state.Verifier, err = generateRandomToken(); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	url, err := provider.AuthCodeURL(ctx, stateId, state.Nonce, state.Verifier)
+	if err != nil {
+		h.providerErrorResponse(w, r, err)
+		return
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	expTime := time.Duration(viper.GetInt("oidc.stateExpTime")) * time.Minute
+	if err = h.redis.Set(oidcStateKey(stateId), data, expTime).Err(); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    hashToken(stateId),
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(expTime.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// oidcCallback finishes the authorization code flow. It checks the state,
+// exchanges the code for the ID token and verifies it. Then it finds the
+// user linked to the provider account or links it by verified email,
+// creating a new user if needed, and signs the user in.
+func (h *Handler) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.readProviderParam(r)
+	if !ok {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+
+	if providerError := query.Get("error"); providerError != "" {
+		h.badRequestResponse(w, r, errors.New("the provider has denied the signin: "+providerError))
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		h.badRequestResponse(w, r, errors.New("empty code"))
+		return
+	}
+
+	stateId := query.Get("state")
+
+	// The callback must be opened in the browser which has started the signin
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(hashToken(stateId)), []byte(cookie.Value)) != 1 {
+		h.badRequestResponse(w, r, errInvalidOidcState)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/auth/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	state, err := h.consumeOidcState(stateId)
+	if err != nil {
+		if errors.Is(err, errInvalidOidcState) {
+			h.badRequestResponse(w, r, err)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if state.Provider != provider.Name {
+		h.badRequestResponse(w, r, errInvalidOidcState)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	idToken, err := provider.Exchange(ctx, code, state.Verifier)
+	if err != nil {
+		h.providerErrorResponse(w, r, err)
+		return
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, idToken, state.Nonce)
+	if err != nil {
+		h.logger.Warnw("invalid id token", "provider", provider.Name, "error", err)
+		h.unauthorizedResponse(w, r)
+		return
+	}
+
+	user, err := h.findOrCreateOidcUser(ctx, provider.Name, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedEmail):
+			h.errorResponse(w, r, http.StatusForbidden, err.Error())
+		case errors.Is(err, errUnverifiedAccount):
+			h.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	h.completeSignin(w, r, user)
+}
+
+// findOrCreateOidcUser returns the user linked to the provider account.
+// If there is no such user, the account is linked to the verified user
+// with the same email or a new user is created.
+func (h *Handler) findOrCreateOidcUser(ctx context.Context, provider string, claims *oidc.Claims) (*model.User, error) {
+	user, err := h.store.Identity().FindUser(ctx, provider, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, errNoRows) {
+		return nil, err
+	}
+
+	// Accounts are linked only by verified emails, otherwise anyone could
+	// take over an account by using its email at the provider
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	identity := model.UserIdentity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    strings.ToLower(claims.Email),
+	}
+
+	user, err = h.store.User().FindByEmail(ctx, identity.Email)
+	if err == nil {
+		// Anyone could have registered an unverified account with the email
+		// and would keep signing in with its password after it is linked
+		if !user.IsVerified {
+			return nil, errUnverifiedAccount
+		}
+
+		user.ClearPassword()
+		identity.UserId = user.Id
+
+		if _, err = h.store.Identity().Create(ctx, &identity); err != nil {
+			return nil, err
+		}
+
+		h.logger.Infow("linked oidc identity", "provider", provider, "user_id", user.Id)
+		return user, nil
+	}
+	if !errors.Is(err, errNoRows) {
+		return nil, err
+	}
+
+	// The provider has verified the email, so the user doesn't have to
+	user = &model.User{
+		Username:   oidcUsername(claims),
+		Email:      identity.Email,
+		IsVerified: true,
+	}
+
+	if _, err = h.store.Identity().CreateWithUser(ctx, user, &identity); err != nil {
+		return nil, err
+	}
+
+	h.logger.Infow("created user with oidc identity", "provider", provider, "user_id", user.Id)
+	return user, nil
+}
+
+// readProviderParam returns the provider which name is in URL
+func (h *Handler) readProviderParam(r *http.Request) (*oidc.Provider, bool) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+	provider, ok := h.providers[name]
+	return provider, ok
+}
+
+// oidcStateKey returns the cache key of the signin state
+func oidcStateKey(state string) string {
+	return "oidc_state:" + state
+}
+
+// consumeOidcState returns the signin state and removes
+// it from cache, so the callback can't be replayed
+func (h *Handler) consumeOidcState(stateId string) (*oidcState, error) {
+	if stateId == "" {
+		return nil, errInvalidOidcState
+	}
+
+	key := oidcStateKey(stateId)
+
+	data, err := h.redis.Get(key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, errInvalidOidcState
+		}
+		return nil, err
+	}
+
+	// Only the request which actually deleted the state may use it
+	deleted, err := h.redis.Del(key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if deleted == 0 {
+		return nil, errInvalidOidcState
+	}
+
+	var state oidcState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// oidcUsername makes a valid username from the provider claims
+func oidcUsername(claims *oidc.Claims) string {
+	name := claims.PreferredUsername
+	if name == "" {
+		name = strings.Split(claims.Email, "@")[0]
+	}
+
+	username := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return -1
+	}, name)
+
+	if len(username) < 3 {
+		username = "user" + username
+	}
+	if len(username) > 20 {
+		username = username[:20]
+	}
+
+	return username
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/spf13/viper"
+)
+
+var errInvalidResetToken = errors.New("invalid or expired token")
+
+// forgotPassword creates a single-use password reset token and sends
+// the reset link to the user email. It responds with the same message
+// whether the email is registered or not, so it can't be used to
+// find out registered emails.
+func (h *Handler) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var input model.ForgotPasswordDto
+
+	if err := readJSON(w, r, &input); err != nil {
+		h.invalidRequestBodyResponse(w, r)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	user, err := h.store.User().FindByEmail(ctx, strings.ToLower(input.Email))
+	if err != nil && !errors.Is(err, errNoRows) {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if err == nil {
+		expTime := time.Duration(viper.GetInt("auth.resetTokenExpTime")) * time.Minute
+
+		token, err := h.createPasswordResetToken(user.Id, expTime)
+		if err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+
+		h.sendTemplateEmail(user.Email, viper.GetString("mail.resetSubject"), "password_reset.html", struct {
+			Username  string
+			ResetLink string
+			ExpiresIn int
+		}{
+			Username:  user.Username,
+			ResetLink: viper.GetString("mail.resetUrl") + "?token=" + token,
+			ExpiresIn: int(expTime.Minutes()),
+		})
+	}
+
+	message := jsonResponse{"message": "if the email is registered, the password reset link has been sent to it"}
+
+	if err := sendJSON(w, message, http.StatusOK, nil); err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// resetPassword verifies the password reset token, sets a new user
+// password and closes every user session.
+func (h *Handler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var input model.ResetPasswordDto
+
+	if err := readJSON(w, r, &input); err != nil {
+		h.invalidRequestBodyResponse(w, r)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	userId, err := h.consumePasswordResetToken(input.Token)
+	if err != nil {
+		if errors.Is(err, errInvalidResetToken) {
+			h.badRequestResponse(w, r, err)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := model.User{Password: input.Password}
+	if err = user.HashPassword(); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	err = h.store.User().Update(ctx, userId, &model.UpdateUserDto{Password: &user.Password})
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err = h.revokeUserSessions(userId, ""); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendJSON(w, nil, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// passwordResetKey returns the cache key of the password reset token.
+// Tokens are stored hashed, so leaked cache data can't be used to
+// reset passwords.
+func passwordResetKey(token string) string {
+	return "password_reset:" + hashToken(token)
+}
+
+// createPasswordResetToken generates a new password reset token
+// for the user and saves it in the cache with given exp time
+func (h *Handler) createPasswordResetToken(userId int, expTime time.Duration) (string, error) {
+	token, err := generateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = h.redis.Set(passwordResetKey(token), strconv.Itoa(userId), expTime).Err()
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// consumePasswordResetToken returns the user id the token was issued for
+// and removes the token from cache, so it can't be used again
+func (h *Handler) consumePasswordResetToken(token string) (int, error) {
+	key := passwordResetKey(token)
+
+	userIdString, err := h.redis.Get(key).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, errInvalidResetToken
+		}
+		return 0, err
+	}
+
+	// Only the request which actually deleted the token may use it
+	deleted, err := h.redis.Del(key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if deleted == 0 {
+		return 0, errInvalidResetToken
+	}
+
+	return strconv.Atoi(userIdString)
+}
@@ -0,0 +1,69 @@
+package handler
+
+import "github.com/juicyluv/astral/internal/model"
+
+// isPrivileged reports whether the role is allowed
+// to moderate content of other users.
+func isPrivileged(role string) bool {
+	return role == model.RoleModerator || role == model.RoleAdmin
+}
+
+// canModifyUser reports whether the token owner is allowed to
+// update or delete the account with given id. Only the account
+// owner and administrators are allowed to do that.
+func canModifyUser(token *model.TokenMetadata, userId int) bool {
+	return token.UserId == userId || token.Role == model.RoleAdmin
+}
+
+// canChangeRole reports whether the token owner is allowed
+// to grant or revoke user roles.
+func canChangeRole(token *model.TokenMetadata) bool {
+	return token.Role == model.RoleAdmin
+}
+
+// canChangeVerification reports whether the token owner is
+// allowed to mark user emails as verified or unverified.
+func canChangeVerification(token *model.TokenMetadata) bool {
+	return token.Role == model.RoleAdmin
+}
+
+// canModifyPost reports whether the token owner is allowed to
+// update the given post. Post authors, including co-authors,
+// moderators and administrators are allowed to do that.
+func canModifyPost(token *model.TokenMetadata, post *model.Post) bool {
+	return post.HasAuthor(token.UserId) || isPrivileged(token.Role)
+}
+
+// canDeletePost reports whether the token owner is allowed to
+// delete the given post. Co-authors are not allowed to do that,
+// only the owner of the post, moderators and administrators.
+func canDeletePost(token *model.TokenMetadata, post *model.Post) bool {
+	return token.UserId == post.Author.Id || isPrivileged(token.Role)
+}
+
+// canManagePostAuthors reports whether the token owner is allowed
+// to invite and remove co-authors of the given post. Only the
+// owner of the post is allowed to do that.
+func canManagePostAuthors(token *model.TokenMetadata, post *model.Post) bool {
+	return token.UserId == post.Author.Id
+}
+
+// canModifyComment reports whether the token owner is allowed to
+// update or delete the given comment. Only the author is allowed
+// to do that.
+func canModifyComment(token *model.TokenMetadata, comment *model.Comment) bool {
+	return comment.Author != nil && token.UserId == comment.Author.Id
+}
+
+// canViewPost reports whether the token owner is allowed to read
+// the given post. Drafts and scheduled posts are visible only to
+// the authors. Token is nil for anonymous users.
+func canViewPost(token *model.TokenMetadata, post *model.Post) bool {
+	return post.IsVisible() || (token != nil && post.HasAuthor(token.UserId))
+}
+
+// canChangePostAuthor reports whether the token owner
+// is allowed to pass the post to another author.
+func canChangePostAuthor(token *model.TokenMetadata) bool {
+	return isPrivileged(token.Role)
+}
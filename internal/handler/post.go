@@ -4,24 +4,18 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/juicyluv/astral/internal/handler/filter"
+	"github.com/juicyluv/astral/internal/markdown"
 	"github.com/juicyluv/astral/internal/model"
+	"github.com/juicyluv/astral/internal/queue"
+	"github.com/juicyluv/astral/internal/store"
 )
 
 // createPost will parse request body and create a new post
 func (h *Handler) createPost(w http.ResponseWriter, r *http.Request) {
-	token, err := h.getTokenMetadata(r)
-	if err != nil {
-		h.unauthorizedResponse(w, r)
-		return
-	}
-
-	userId, err := h.fetchTokenDataFromRedis(token)
-	if err != nil {
-		h.unauthorizedResponse(w, r)
-		return
-	}
+	token := contextGetToken(r)
 
 	var post model.Post
 
@@ -30,12 +24,27 @@ func (h *Handler) createPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	post.Author.Id = userId
+	post.Author.Id = token.UserId
+
+	// Posts are published right away unless they are saved as drafts or scheduled
+	if post.Status == "" {
+		post.Status = model.PostStatusPublished
+	}
+	if post.Format == "" {
+		post.Format = model.PostFormatPlain
+	}
 
 	if err := post.Validate(); err != nil {
 		h.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
+	post.Tags = model.NormalizeTags(post.Tags)
+	post.ContentHTML = renderContent(post.Format, post.Content)
+
+	if post.Status == model.PostStatusPublished {
+		now := time.Now()
+		post.PublishedAt = &now
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
 	defer cancel()
@@ -46,22 +55,33 @@ func (h *Handler) createPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if post.Status == model.PostStatusPublished {
+		h.dispatchPostPublished(&post)
+	}
+
 	err = sendJSON(w, jsonResponse{"id": postId}, http.StatusOK, nil)
 	if err != nil {
 		h.internalErrorResponse(w, r, err)
 	}
 }
 
-// listPost will parse URL query to filter posts and returns a list of
-// required posts
+// listPost will parse URL query to filter posts and returns a page of
+// required posts with the cursor of the next page
 func (h *Handler) listPost(w http.ResponseWriter, r *http.Request) {
-	title := r.URL.Query().Get("title")
-	filter := filter.PostFilter{Title: title}
+	filter, err := filter.NewPostFilter(r.URL.Query())
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	if token, ok := contextFindToken(r); ok {
+		filter.ViewerId = token.UserId
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
 	defer cancel()
 
-	posts, err := h.store.Post().FindAll(ctx, &filter)
+	posts, next, err := h.store.Post().FindAll(ctx, filter)
 	if err != nil {
 		if errors.Is(err, errNoRows) {
 			h.recordNotFoundResponse(w, r)
@@ -71,7 +91,51 @@ func (h *Handler) listPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = sendJSON(w, posts, http.StatusOK, nil)
+	if err = h.setMyPostReactions(ctx, r, postPointers(posts)); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendPage(w, r, "posts", posts, next.Encode())
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// searchPosts will parse URL query and return a page of posts which
+// match the search query, the most relevant first
+func (h *Handler) searchPosts(w http.ResponseWriter, r *http.Request) {
+	search, err := filter.NewSearchFilter(r.URL.Query())
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	results, more, err := h.store.Post().Search(ctx, search)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	posts := make([]*model.Post, 0, len(results))
+	for i := range results {
+		posts = append(posts, &results[i].Post)
+	}
+
+	if err = h.setMyPostReactions(ctx, r, posts); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	next := ""
+	if more {
+		next = filter.EncodeOffset(search.Offset + search.Limit)
+	}
+
+	err = sendPage(w, r, "posts", results, next)
 	if err != nil {
 		h.internalErrorResponse(w, r, err)
 	}
@@ -98,7 +162,18 @@ func (h *Handler) getPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = sendJSON(w, post, http.StatusOK, nil)
+	token, _ := contextFindToken(r)
+	if !canViewPost(token, post) {
+		h.recordNotFoundResponse(w, r)
+		return
+	}
+
+	if err = h.setMyPostReactions(ctx, r, []*model.Post{post}); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendJSON(w, post, http.StatusOK, versionHeaders(post.Version))
 	if err != nil {
 		h.internalErrorResponse(w, r, err)
 	}
@@ -116,6 +191,29 @@ func (h *Handler) updatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	version, ok := readIfMatch(r)
+	if !ok {
+		h.preconditionFailedResponse(w, r)
+		return
+	}
+
+	token := contextGetToken(r)
+
+	found, err := h.store.Post().FindById(ctx, postId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !canModifyPost(token, found) {
+		h.forbiddenResponse(w, r)
+		return
+	}
+
 	var post model.UpdatePostDto
 
 	if err := readJSON(w, r, &post); err != nil {
@@ -128,8 +226,46 @@ func (h *Handler) updatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if post.Tags != nil {
+		tags := model.NormalizeTags(*post.Tags)
+		post.Tags = &tags
+	}
+	post.Version = version
+
+	if err := post.ValidateStatus(found); err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The content is rendered again when the content or its format are changed
+	if post.Content != nil || post.Format != nil {
+		content, format := found.Content, found.Format
+		if post.Content != nil {
+			content = *post.Content
+		}
+		if post.Format != nil {
+			format = *post.Format
+		}
+
+		contentHTML := renderContent(format, content)
+		post.ContentHTML = &contentHTML
+	}
+
+	// Drafts and scheduled posts get the publishing time when they are published
+	published := post.Status != nil && *post.Status == model.PostStatusPublished &&
+		(found.Status == model.PostStatusDraft || found.Status == model.PostStatusScheduled)
+	if published {
+		now := time.Now()
+		post.PublishedAt = &now
+	}
+
 	// If updating post's author, check if author with this id exists
 	if post.AuthorId != nil {
+		if !canChangePostAuthor(token) {
+			h.forbiddenResponse(w, r)
+			return
+		}
+
 		_, err = h.store.User().FindById(ctx, *post.AuthorId)
 		if err != nil {
 			if errors.Is(err, errNoRows) {
@@ -141,16 +277,30 @@ func (h *Handler) updatePost(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	err = h.store.Post().Update(ctx, int(postId), &post)
+	err = h.store.Post().Update(ctx, int(postId), token.UserId, &post)
 	if err != nil {
-		if errors.Is(err, errNoRows) {
+		switch {
+		case errors.Is(err, errNoRows):
 			h.recordNotFoundResponse(w, r)
-		} else {
+		case errors.Is(err, store.ErrVersionConflict):
+			h.preconditionFailedResponse(w, r)
+		default:
 			h.internalErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	if published {
+		found.PublishedAt = post.PublishedAt
+		if post.Title != nil {
+			found.Title = *post.Title
+		}
+		if post.AuthorId != nil {
+			found.Author.Id = *post.AuthorId
+		}
+		h.dispatchPostPublished(found)
+	}
+
 	err = sendJSON(w, nil, http.StatusOK, nil)
 	if err != nil {
 		h.internalErrorResponse(w, r, err)
@@ -168,6 +318,21 @@ func (h *Handler) deletePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	post, err := h.store.Post().FindById(ctx, postId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !canDeletePost(contextGetToken(r), post) {
+		h.forbiddenResponse(w, r)
+		return
+	}
+
 	err = h.store.Post().Delete(ctx, int(postId))
 	if err != nil {
 		if errors.Is(err, errNoRows) {
@@ -183,3 +348,34 @@ func (h *Handler) deletePost(w http.ResponseWriter, r *http.Request) {
 		h.internalErrorResponse(w, r, err)
 	}
 }
+
+// renderContent renders the post content of the format to sanitized HTML
+func renderContent(format, content string) string {
+	if format == model.PostFormatMarkdown {
+		return markdown.Render(content)
+	}
+	return markdown.RenderPlain(content)
+}
+
+// dispatchPostPublished emits the event about the published post to the
+// queue and adds the post to the timelines of the followers in background
+func (h *Handler) dispatchPostPublished(post *model.Post) {
+	err := h.queue.DispatchEvent(queue.EventPostPublished, queue.PostPublished{
+		PostId:      post.Id,
+		AuthorId:    post.Author.Id,
+		Title:       post.Title,
+		PublishedAt: *post.PublishedAt,
+	})
+	if err != nil {
+		h.logger.Errorf("could not dispatch post published event: %v", err)
+	}
+
+	go func(postId int, publishedAt time.Time) {
+		ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+		defer cancel()
+
+		if err := h.feed.FanOut(ctx, postId, publishedAt); err != nil {
+			h.logger.Errorf("could not add post %d to timelines: %v", postId, err)
+		}
+	}(post.Id, *post.PublishedAt)
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// addPostReaction will parse post id and reaction kind from URL and
+// leave the reaction of the authenticated user on the post
+func (h *Handler) addPostReaction(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, func(ctx context.Context, id, userId int, kind string) error {
+		if err := h.checkPostVisible(ctx, r, id); err != nil {
+			return err
+		}
+		return h.store.Reaction().AddPostReaction(ctx, id, userId, kind)
+	})
+}
+
+// removePostReaction will parse post id and reaction kind from URL
+// and remove the reaction of the authenticated user from the post
+func (h *Handler) removePostReaction(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, func(ctx context.Context, id, userId int, kind string) error {
+		if err := h.checkPostVisible(ctx, r, id); err != nil {
+			return err
+		}
+		return h.store.Reaction().RemovePostReaction(ctx, id, userId, kind)
+	})
+}
+
+// addCommentReaction will parse comment id and reaction kind from URL
+// and leave the reaction of the authenticated user on the comment
+func (h *Handler) addCommentReaction(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, func(ctx context.Context, id, userId int, kind string) error {
+		comment, err := h.findVisibleComment(ctx, r, id)
+		if err != nil {
+			return err
+		}
+		// Tombstones can't get new reactions
+		if comment.Deleted {
+			return errNoRows
+		}
+		return h.store.Reaction().AddCommentReaction(ctx, id, userId, kind)
+	})
+}
+
+// removeCommentReaction will parse comment id and reaction kind from URL
+// and remove the reaction of the authenticated user from the comment
+func (h *Handler) removeCommentReaction(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, func(ctx context.Context, id, userId int, kind string) error {
+		if _, err := h.findVisibleComment(ctx, r, id); err != nil {
+			return err
+		}
+		return h.store.Reaction().RemoveCommentReaction(ctx, id, userId, kind)
+	})
+}
+
+// checkPostVisible returns errNoRows if the post doesn't exist or the
+// authenticated user is not allowed to see it, so reactions don't reveal
+// drafts and scheduled posts
+func (h *Handler) checkPostVisible(ctx context.Context, r *http.Request, postId int) error {
+	post, err := h.store.Post().FindById(ctx, postId)
+	if err != nil {
+		return err
+	}
+	if !canViewPost(contextGetToken(r), post) {
+		return errNoRows
+	}
+	return nil
+}
+
+// findVisibleComment returns the comment if the authenticated
+// user is allowed to see the post of the comment
+func (h *Handler) findVisibleComment(ctx context.Context, r *http.Request, commentId int) (*model.Comment, error) {
+	comment, err := h.store.Comment().FindById(ctx, commentId)
+	if err != nil {
+		return nil, err
+	}
+	if err = h.checkPostVisible(ctx, r, comment.PostId); err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// changeReaction parses the id and the reaction kind from URL
+// and applies the change for the authenticated user
+func (h *Handler) changeReaction(w http.ResponseWriter, r *http.Request, change func(context.Context, int, int, string) error) {
+	id, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	kind := httprouter.ParamsFromContext(r.Context()).ByName("kind")
+	if !model.IsReactionKind(kind) {
+		message := fmt.Sprintf("reaction must be one of: %s", strings.Join(model.ReactionKinds, ", "))
+		h.badRequestResponse(w, r, errors.New(message))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	err = change(ctx, id, contextGetToken(r).UserId, kind)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = sendJSON(w, nil, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// setMyPostReactions sets reactions of the authenticated user on the
+// posts. It does nothing for anonymous requests.
+func (h *Handler) setMyPostReactions(ctx context.Context, r *http.Request, posts []*model.Post) error {
+	token, ok := contextFindToken(r)
+	if !ok || len(posts) == 0 {
+		return nil
+	}
+
+	postIds := make([]int, 0, len(posts))
+	for _, post := range posts {
+		postIds = append(postIds, post.Id)
+	}
+
+	reactions, err := h.store.Reaction().FindUserPostReactions(ctx, token.UserId, postIds)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		post.MyReactions = reactions[post.Id]
+	}
+
+	return nil
+}
+
+// setMyCommentReactions sets reactions of the authenticated user on the
+// comments and their replies. It does nothing for anonymous requests.
+func (h *Handler) setMyCommentReactions(ctx context.Context, r *http.Request, comments []*model.Comment) error {
+	token, ok := contextFindToken(r)
+	if !ok || len(comments) == 0 {
+		return nil
+	}
+
+	// Replies of the tree view are collected as well
+	all := append([]*model.Comment{}, comments...)
+	for i := 0; i < len(all); i++ {
+		all = append(all, all[i].Replies...)
+	}
+
+	commentIds := make([]int, 0, len(all))
+	for _, comment := range all {
+		commentIds = append(commentIds, comment.Id)
+	}
+
+	reactions, err := h.store.Reaction().FindUserCommentReactions(ctx, token.UserId, commentIds)
+	if err != nil {
+		return err
+	}
+
+	for _, comment := range all {
+		comment.MyReactions = reactions[comment.Id]
+	}
+
+	return nil
+}
+
+// postPointers returns pointers to the posts, so they can be changed in place
+func postPointers(posts []model.Post) []*model.Post {
+	pointers := make([]*model.Post, 0, len(posts))
+	for i := range posts {
+		pointers = append(pointers, &posts[i])
+	}
+	return pointers
+}
+
+// commentPointers returns pointers to the comments, so they can be changed in place
+func commentPointers(comments []model.Comment) []*model.Comment {
+	pointers := make([]*model.Comment, 0, len(comments))
+	for i := range comments {
+		pointers = append(pointers, &comments[i])
+	}
+	return pointers
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/juicyluv/astral/internal/diff"
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/juicyluv/astral/internal/store"
+)
+
+// diffContextLines is a number of unchanged lines shown around changes
+const diffContextLines = 3
+
+// listRevisions will parse post id from URL and return revisions
+// of the post without their content, the latest revision first
+func (h *Handler) listRevisions(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	post, ok := h.findRevisionsPost(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	revisions, err := h.store.Post().FindRevisions(ctx, post.Id)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendJSON(w, revisions, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// getRevision will parse post id and revision number
+// from URL and return the revision with its content
+func (h *Handler) getRevision(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	revision, ok := h.findRevision(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	err := sendJSON(w, revision, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// diffRevisions returns a unified line diff between the revision from URL
+// and the revision from "from" query parameter, which is the previous
+// revision by default. The title is compared as the first line of the post.
+// The first revision is compared with an empty post unless "from" is set.
+func (h *Handler) diffRevisions(w http.ResponseWriter, r *http.Request) {
+	var fromNumber int
+	if param := r.URL.Query().Get("from"); param != "" {
+		number, err := strconv.Atoi(param)
+		if err != nil || number < 1 {
+			h.errorResponse(w, r, http.StatusBadRequest, "invalid from parameter")
+			return
+		}
+		fromNumber = number
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	to, ok := h.findRevision(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	if fromNumber == 0 {
+		fromNumber = to.Revision - 1
+	}
+
+	from := &model.PostRevision{}
+	if fromNumber > 0 {
+		found, err := h.store.Post().FindRevision(ctx, to.PostId, fromNumber)
+		if err != nil {
+			if errors.Is(err, errNoRows) {
+				h.errorResponse(w, r, http.StatusNotFound, "the revision to compare with could not be found")
+			} else {
+				h.internalErrorResponse(w, r, err)
+			}
+			return
+		}
+		from = found
+	}
+
+	result := model.PostRevisionDiff{
+		From: fromNumber,
+		To:   to.Revision,
+		Diff: diff.Unified(
+			fmt.Sprintf("revision %d", fromNumber),
+			fmt.Sprintf("revision %d", to.Revision),
+			revisionText(from),
+			revisionText(to),
+			diffContextLines,
+		),
+	}
+
+	err := sendJSON(w, result, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// restoreRevision will parse post id and revision number from URL and
+// set the title and content of the post back to the revision. Restoring
+// is an update itself, so it creates a new revision.
+func (h *Handler) restoreRevision(w http.ResponseWriter, r *http.Request) {
+	version, ok := readIfMatch(r)
+	if !ok {
+		h.preconditionFailedResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	revision, ok := h.findRevision(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	token := contextGetToken(r)
+
+	contentHTML := renderContent(revision.Format, revision.Content)
+
+	update := model.UpdatePostDto{
+		Title:       &revision.Title,
+		Content:     &revision.Content,
+		Format:      &revision.Format,
+		ContentHTML: &contentHTML,
+		Version:     version,
+	}
+
+	err := h.store.Post().Update(ctx, revision.PostId, token.UserId, &update)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNoRows):
+			h.recordNotFoundResponse(w, r)
+		case errors.Is(err, store.ErrVersionConflict):
+			h.preconditionFailedResponse(w, r)
+		default:
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	post, err := h.store.Post().FindById(ctx, revision.PostId)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendJSON(w, post, http.StatusOK, versionHeaders(post.Version))
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// findRevisionsPost finds the post from URL and checks whether the token
+// owner is allowed to see its revisions. History of the post is available
+// to those who are allowed to modify the post. It responds with an error
+// and returns false on failure.
+func (h *Handler) findRevisionsPost(ctx context.Context, w http.ResponseWriter, r *http.Request) (*model.Post, bool) {
+	postId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	post, err := h.store.Post().FindById(ctx, postId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !canModifyPost(contextGetToken(r), post) {
+		h.forbiddenResponse(w, r)
+		return nil, false
+	}
+
+	return post, true
+}
+
+// findRevision finds the revision from URL of the post which
+// revisions the token owner is allowed to see. It responds
+// with an error and returns false on failure.
+func (h *Handler) findRevision(ctx context.Context, w http.ResponseWriter, r *http.Request) (*model.PostRevision, bool) {
+	number, err := readRevisionParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	post, ok := h.findRevisionsPost(ctx, w, r)
+	if !ok {
+		return nil, false
+	}
+
+	revision, err := h.store.Post().FindRevision(ctx, post.Id, number)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return revision, true
+}
+
+// revisionText joins the title and the content of the revision to compare
+func revisionText(revision *model.PostRevision) string {
+	if revision.Revision == 0 {
+		return ""
+	}
+	return revision.Title + "\n\n" + revision.Content
+}
@@ -1,32 +1,116 @@
 package handler
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
 
 func (h *Handler) initRoutes() {
 	h.router.NotFound = http.HandlerFunc(h.notFoundResponse)
 	h.router.MethodNotAllowed = http.HandlerFunc(h.methodNotAllowedResponse)
 
+	// session allows only session tokens, scoped allows
+	// personal access tokens which have been granted the scope
+	session := func(next http.HandlerFunc) http.HandlerFunc {
+		return h.RequireAuth(h.RequireSession(next))
+	}
+	scoped := func(scope string, next http.HandlerFunc) http.HandlerFunc {
+		return h.RequireAuth(h.RequireScope(scope, next))
+	}
+
 	// Helpers
 	h.router.HandlerFunc(http.MethodGet, "/api/health", h.health)
+	h.router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", h.jwks)
 
 	// Auth
 	h.router.HandlerFunc(http.MethodPost, "/api/auth/signin", h.login)
+	h.router.HandlerFunc(http.MethodPost, "/api/auth/signin/2fa", h.loginTwoFactor)
+	h.router.HandlerFunc(http.MethodPost, "/api/auth/magic-link", h.requestMagicLink)
+	h.router.HandlerFunc(http.MethodGet, "/api/auth/magic-link/callback", h.magicLinkCallback)
+	h.router.HandlerFunc(http.MethodGet, "/api/auth/oidc/:provider/login", h.oidcLogin)
+	h.router.HandlerFunc(http.MethodGet, "/api/auth/oidc/:provider/callback", h.oidcCallback)
 	h.router.HandlerFunc(http.MethodPost, "/api/auth/signup", h.createUser)
-	h.router.HandlerFunc(http.MethodGet, "/api/auth/signout", h.RequireAuth(h.logout))
+	h.router.HandlerFunc(http.MethodGet, "/api/auth/signout", session(h.logout))
 	h.router.HandlerFunc(http.MethodPost, "/api/auth/refresh", h.refreshToken)
+	h.router.HandlerFunc(http.MethodPost, "/api/auth/verification/resend", session(h.resendConfirmation))
+	h.router.HandlerFunc(http.MethodPost, "/api/auth/email", session(h.changeEmail))
+	h.router.HandlerFunc(http.MethodGet, "/api/auth/email/confirm", h.confirmEmailChange)
+	h.router.HandlerFunc(http.MethodGet, "/api/auth/email/cancel", h.cancelEmailChange)
+	h.router.HandlerFunc(http.MethodPost, "/api/auth/password/forgot", h.forgotPassword)
+	h.router.HandlerFunc(http.MethodPost, "/api/auth/password/reset", h.resetPassword)
+	h.router.HandlerFunc(http.MethodGet, "/api/auth/sessions", session(h.listSessions))
+	h.router.HandlerFunc(http.MethodDelete, "/api/auth/sessions", session(h.deleteAllSessions))
+	h.router.HandlerFunc(http.MethodDelete, "/api/auth/sessions/:id", session(h.deleteSession))
+	h.router.HandlerFunc(http.MethodPost, "/api/auth/2fa/enroll", session(h.enrollTwoFactor))
+	h.router.HandlerFunc(http.MethodPost, "/api/auth/2fa/confirm", session(h.confirmTwoFactor))
+	h.router.HandlerFunc(http.MethodPost, "/api/auth/2fa/disable", session(h.disableTwoFactor))
+	h.router.HandlerFunc(http.MethodPost, "/api/auth/2fa/recovery-codes", session(h.regenerateRecoveryCodes))
+	h.router.HandlerFunc(http.MethodGet, "/api/auth/tokens", session(h.listPersonalTokens))
+	h.router.HandlerFunc(http.MethodPost, "/api/auth/tokens", session(h.createPersonalToken))
+	h.router.HandlerFunc(http.MethodDelete, "/api/auth/tokens/:id", session(h.deletePersonalToken))
 
 	// Users
 	h.router.HandlerFunc(http.MethodGet, "/api/users", h.listUser)
 	h.router.HandlerFunc(http.MethodGet, "/api/users/:id", h.getUser)
-	h.router.HandlerFunc(http.MethodPut, "/api/users/:id", h.RequireAuth(h.updateUser))
-	h.router.HandlerFunc(http.MethodDelete, "/api/users/:id", h.RequireAuth(h.deleteUser))
-	h.router.HandlerFunc(http.MethodGet, "/api/users/:id/posts", h.listUserPosts)
+	h.router.HandlerFunc(http.MethodPut, "/api/users/:id", scoped(model.ScopeUsersWrite, h.RequireVerified(h.updateUser)))
+	h.router.HandlerFunc(http.MethodDelete, "/api/users/:id", session(h.deleteUser))
+	h.router.HandlerFunc(http.MethodGet, "/api/users/:id/posts", h.OptionalAuth(h.listUserPosts))
+	h.router.HandlerFunc(http.MethodPut, "/api/users/:id/avatar", scoped(model.ScopeUsersWrite, h.RequireVerified(h.uploadAvatar)))
+	h.router.HandlerFunc(http.MethodGet, "/api/avatars/:name", h.getAvatar)
+	h.router.HandlerFunc(http.MethodGet, "/api/users/:id/followers", h.listFollowers)
+	h.router.HandlerFunc(http.MethodGet, "/api/users/:id/following", h.listFollowing)
+	h.router.HandlerFunc(http.MethodPost, "/api/users/:id/follow", scoped(model.ScopeUsersWrite, h.followUser))
+	h.router.HandlerFunc(http.MethodDelete, "/api/users/:id/follow", scoped(model.ScopeUsersWrite, h.unfollowUser))
 	h.router.HandlerFunc(http.MethodGet, "/api/confirmation", h.confirmEmail)
 
 	// Posts
-	h.router.HandlerFunc(http.MethodGet, "/api/posts", h.listPost)
-	h.router.HandlerFunc(http.MethodPost, "/api/posts", h.RequireAuth(h.createPost))
-	h.router.HandlerFunc(http.MethodGet, "/api/posts/:id", h.getPost)
-	h.router.HandlerFunc(http.MethodPut, "/api/posts/:id", h.RequireAuth(h.updatePost))
-	h.router.HandlerFunc(http.MethodDelete, "/api/posts/:id", h.RequireAuth(h.deletePost))
+	h.router.HandlerFunc(http.MethodGet, "/api/feed", scoped(model.ScopeUsersRead, h.getFeed))
+	h.router.HandlerFunc(http.MethodGet, "/api/posts", h.OptionalAuth(h.listPost))
+	h.router.HandlerFunc(http.MethodPost, "/api/posts", scoped(model.ScopePostsWrite, h.RequireVerified(h.createPost)))
+	h.router.HandlerFunc(http.MethodGet, "/api/posts/:id", h.OptionalAuth(staticParam("id", "search", h.searchPosts, h.getPost)))
+	h.router.HandlerFunc(http.MethodPut, "/api/posts/:id", scoped(model.ScopePostsWrite, h.updatePost))
+	h.router.HandlerFunc(http.MethodDelete, "/api/posts/:id", scoped(model.ScopePostsWrite, h.deletePost))
+	h.router.HandlerFunc(http.MethodGet, "/api/posts/:id/comments", h.OptionalAuth(h.listComments))
+	h.router.HandlerFunc(http.MethodPut, "/api/posts/:id/reactions/:kind", scoped(model.ScopePostsWrite, h.addPostReaction))
+	h.router.HandlerFunc(http.MethodDelete, "/api/posts/:id/reactions/:kind", scoped(model.ScopePostsWrite, h.removePostReaction))
+	h.router.HandlerFunc(http.MethodPost, "/api/posts/:id/comments", scoped(model.ScopeCommentsWrite, h.RequireVerified(h.createComment)))
+	h.router.HandlerFunc(http.MethodPost, "/api/posts/:id/authors", scoped(model.ScopePostsWrite, h.inviteAuthor))
+	h.router.HandlerFunc(http.MethodPost, "/api/posts/:id/authors/accept", scoped(model.ScopePostsWrite, h.acceptAuthorInvite))
+	h.router.HandlerFunc(http.MethodDelete, "/api/posts/:id/authors/:user_id", scoped(model.ScopePostsWrite, h.removeAuthor))
+	h.router.HandlerFunc(http.MethodGet, "/api/posts/:id/revisions", scoped(model.ScopePostsWrite, h.listRevisions))
+	h.router.HandlerFunc(http.MethodGet, "/api/posts/:id/revisions/:rev", scoped(model.ScopePostsWrite, h.getRevision))
+	h.router.HandlerFunc(http.MethodGet, "/api/posts/:id/revisions/:rev/diff", scoped(model.ScopePostsWrite, h.diffRevisions))
+	h.router.HandlerFunc(http.MethodPost, "/api/posts/:id/revisions/:rev/restore", scoped(model.ScopePostsWrite, h.restoreRevision))
+	h.router.HandlerFunc(http.MethodGet, "/api/posts/:id/attachments", h.OptionalAuth(h.listAttachments))
+	h.router.HandlerFunc(http.MethodPost, "/api/posts/:id/attachments", scoped(model.ScopePostsWrite, h.RequireVerified(h.uploadAttachment)))
+
+	// Attachments
+	h.router.HandlerFunc(http.MethodGet, "/api/attachments/:id", h.OptionalAuth(h.getAttachment))
+	h.router.HandlerFunc(http.MethodDelete, "/api/attachments/:id", scoped(model.ScopePostsWrite, h.deleteAttachment))
+
+	// Comments
+	h.router.HandlerFunc(http.MethodPut, "/api/comments/:id", scoped(model.ScopeCommentsWrite, h.updateComment))
+	h.router.HandlerFunc(http.MethodDelete, "/api/comments/:id", scoped(model.ScopeCommentsWrite, h.deleteComment))
+	h.router.HandlerFunc(http.MethodPut, "/api/comments/:id/reactions/:kind", scoped(model.ScopeCommentsWrite, h.addCommentReaction))
+	h.router.HandlerFunc(http.MethodDelete, "/api/comments/:id/reactions/:kind", scoped(model.ScopeCommentsWrite, h.removeCommentReaction))
+
+	// Tags
+	h.router.HandlerFunc(http.MethodGet, "/api/tags", h.listTags)
+	h.router.HandlerFunc(http.MethodGet, "/api/tags/:slug/posts", h.OptionalAuth(h.listTagPosts))
+}
+
+// staticParam routes requests which path parameter equals to the value
+// to the static handler, other requests are routed to next. It is needed
+// since httprouter doesn't allow static path segments next to parameters.
+func staticParam(param, value string, static, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if httprouter.ParamsFromContext(r.Context()).ByName(param) == value {
+			static(w, r)
+			return
+		}
+
+		next(w, r)
+	}
 }
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// touchSessionScript updates the last usage time of the session
+// only if the session exists, so revoked sessions are not recreated
+var touchSessionScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("HSET", KEYS[1], "last_used", ARGV[1])
+end
+return 0
+`)
+
+// listSessions returns every active session of the authenticated user
+func (h *Handler) listSessions(w http.ResponseWriter, r *http.Request) {
+	token := contextGetToken(r)
+
+	sessions, err := h.findUserSessions(token.UserId)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].Id == token.FamilyId
+	}
+
+	err = sendJSON(w, sessions, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// deleteSession parses the session id from URL and revokes
+// the session if it belongs to the authenticated user
+func (h *Handler) deleteSession(w http.ResponseWriter, r *http.Request) {
+	token := contextGetToken(r)
+	sessionId := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	found, err := h.redis.SIsMember(userTokenFamiliesKey(token.UserId), sessionId).Result()
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if !found {
+		h.recordNotFoundResponse(w, r)
+		return
+	}
+
+	if err = h.revokeTokenFamily(sessionId); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendJSON(w, nil, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllSessions revokes every session of the authenticated user,
+// including the current one
+func (h *Handler) deleteAllSessions(w http.ResponseWriter, r *http.Request) {
+	token := contextGetToken(r)
+
+	if err := h.revokeUserSessions(token.UserId, ""); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err := sendJSON(w, nil, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// findUserSessions returns sessions of the user sorted by
+// the last usage time. Expired sessions are removed from the user index.
+func (h *Handler) findUserSessions(userId int) ([]model.Session, error) {
+	key := userTokenFamiliesKey(userId)
+
+	families, err := h.redis.SMembers(key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := h.redis.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(families))
+	for i, familyId := range families {
+		cmds[i] = pipe.HGetAll(tokenFamilyKey(familyId))
+	}
+	if _, err = pipe.Exec(); err != nil {
+		return nil, err
+	}
+
+	sessions := make([]model.Session, 0, len(families))
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+
+		// Session has expired
+		if len(fields) == 0 {
+			if err = h.redis.SRem(key, families[i]).Err(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		sessions = append(sessions, model.Session{
+			Id:        families[i],
+			UserAgent: fields["user_agent"],
+			Ip:        fields["ip"],
+			CreatedAt: parseUnixField(fields["created_at"]),
+			LastUsed:  parseUnixField(fields["last_used"]),
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsed.After(sessions[j].LastUsed)
+	})
+
+	return sessions, nil
+}
+
+// touchSession updates the last usage time of the session
+func (h *Handler) touchSession(familyId string) error {
+	key := tokenFamilyKey(familyId)
+	return touchSessionScript.Run(h.redis, []string{key}, time.Now().Unix()).Err()
+}
+
+// parseUnixField converts the unix time cache field to time.
+// Returns zero time if the field is malformed.
+func parseUnixField(field string) time.Time {
+	sec, err := strconv.ParseInt(field, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/juicyluv/astral/internal/handler/filter"
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// listTags returns every tag which is used by posts
+// with the number of posts, the most used first
+func (h *Handler) listTags(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	tags, err := h.store.Tag().FindAll(ctx)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendJSON(w, tags, http.StatusOK, nil)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// listTagPosts will parse tag slug from URL and return a page of posts
+// with this tag. Posts are filtered the same way as in listPost.
+func (h *Handler) listTagPosts(w http.ResponseWriter, r *http.Request) {
+	slug := model.Slugify(httprouter.ParamsFromContext(r.Context()).ByName("slug"))
+
+	filter, err := filter.NewPostFilter(r.URL.Query())
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	if token, ok := contextFindToken(r); ok {
+		filter.ViewerId = token.UserId
+	}
+	filter.Tags = []string{slug}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	_, err = h.store.Tag().FindBySlug(ctx, slug)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	posts, next, err := h.store.Post().FindAll(ctx, filter)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if err = h.setMyPostReactions(ctx, r, postPointers(posts)); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendPage(w, r, "posts", posts, next.Encode())
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"math"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// maxSigninBlockTime limits the exponential growth of signin blocks
+const maxSigninBlockTime = 24 * time.Hour
+
+// Kinds of signin failure counters
+const (
+	signinByEmail = "email"
+	signinByIp    = "ip"
+)
+
+// signinFailuresKey returns the cache key of the failed signin counter
+func signinFailuresKey(kind, value string) string {
+	return "signin_failures:" + kind + ":" + value
+}
+
+// signinBlockKey returns the cache key which exists
+// while signin attempts are not allowed
+func signinBlockKey(kind, value string) string {
+	return "signin_block:" + kind + ":" + value
+}
+
+// signinRetryAfter returns the time left until the next signin attempt
+// with the email from the IP address is allowed. Zero means it is allowed.
+func (h *Handler) signinRetryAfter(email, ip string) (time.Duration, error) {
+	pipe := h.redis.Pipeline()
+	byEmail := pipe.PTTL(signinBlockKey(signinByEmail, email))
+	byIp := pipe.PTTL(signinBlockKey(signinByIp, ip))
+
+	if _, err := pipe.Exec(); err != nil {
+		return 0, err
+	}
+
+	// PTTL returns negative values for missing keys
+	retryAfter := byEmail.Val()
+	if byIp.Val() > retryAfter {
+		retryAfter = byIp.Val()
+	}
+	if retryAfter < 0 {
+		return 0, nil
+	}
+
+	return retryAfter, nil
+}
+
+// registerSigninFailure counts the failed signin attempt by email and IP
+// address and blocks next attempts. Every failure with the email doubles
+// the block time, after the maximum number of failures the account is
+// locked out. The IP address is blocked only after its maximum number of
+// failures, so users behind a shared address don't block each other.
+// Returns true if the account has just been locked out.
+func (h *Handler) registerSigninFailure(email, ip string) (bool, error) {
+	failures, err := h.countSigninFailure(signinByEmail, email, viper.GetInt("auth.signinMaxFailures"))
+	if err != nil {
+		return false, err
+	}
+
+	_, err = h.countSigninFailure(signinByIp, ip, viper.GetInt("auth.signinMaxIpFailures"))
+	if err != nil {
+		return false, err
+	}
+
+	return failures == viper.GetInt("auth.signinMaxFailures"), nil
+}
+
+// countSigninFailure increments the failure counter and blocks signin
+// attempts for the backoff time. Attempts from an IP address are not
+// blocked before the maximum number of failures.
+func (h *Handler) countSigninFailure(kind, value string, maxFailures int) (int, error) {
+	window := time.Duration(viper.GetInt("auth.signinFailuresWindow")) * time.Minute
+	key := signinFailuresKey(kind, value)
+
+	pipe := h.redis.TxPipeline()
+	incr := pipe.Incr(key)
+	pipe.Expire(key, window)
+
+	if _, err := pipe.Exec(); err != nil {
+		return 0, err
+	}
+
+	failures := int(incr.Val())
+	if kind == signinByIp && failures < maxFailures {
+		return failures, nil
+	}
+
+	block := signinBackoff(failures, maxFailures)
+	if err := h.redis.Set(signinBlockKey(kind, value), failures, block).Err(); err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+// resetSigninFailures forgets failed attempts with the email after
+// the successful signin. Failures by IP address are kept, so an
+// attacker can't reset them with their own account.
+func (h *Handler) resetSigninFailures(email string) error {
+	return h.redis.Del(
+		signinFailuresKey(signinByEmail, email),
+		signinBlockKey(signinByEmail, email),
+	).Err()
+}
+
+// signinBackoff returns how long the next attempt is blocked after
+// the given number of failures. Before the maximum number of failures
+// the base delay is doubled every time, then the lockout time is.
+func signinBackoff(failures, maxFailures int) time.Duration {
+	base := time.Duration(viper.GetInt("auth.signinBackoffBase")) * time.Second
+	lockout := time.Duration(viper.GetInt("auth.signinLockoutTime")) * time.Minute
+
+	// Computed in floats to not overflow durations
+	var block float64
+	if failures < maxFailures {
+		block = float64(base) * math.Pow(2, float64(failures-1))
+	} else {
+		block = float64(lockout) * math.Pow(2, float64(failures-maxFailures))
+	}
+
+	if block > float64(maxSigninBlockTime) {
+		return maxSigninBlockTime
+	}
+
+	return time.Duration(block)
+}
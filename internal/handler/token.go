@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/juicyluv/astral/internal/model"
+)
+
+// personalTokenPrefix is put in front of every personal access token,
+// so they can be told apart from session tokens and found by secret scanners
+const personalTokenPrefix = "astral_pat_"
+
+// createPersonalToken creates a new personal access token for the
+// authenticated user. The token is returned only once, only its hash
+// is stored.
+func (h *Handler) createPersonalToken(w http.ResponseWriter, r *http.Request) {
+	var input model.CreatePersonalAccessTokenDto
+
+	if err := readJSON(w, r, &input); err != nil {
+		h.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	secret, err := generateRandomToken()
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	token := model.PersonalAccessToken{
+		UserId:    contextGetToken(r).UserId,
+		Name:      input.Name,
+		Token:     personalTokenPrefix + secret,
+		Scopes:    input.Scopes,
+		ExpiresAt: input.ExpiresAt,
+	}
+	token.TokenHash = hashToken(token.Token)
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	if _, err = h.store.Token().Create(ctx, &token); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if err = sendJSON(w, token, http.StatusOK, nil); err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// listPersonalTokens returns personal access tokens of the authenticated user
+func (h *Handler) listPersonalTokens(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	tokens, err := h.store.Token().FindUserTokens(ctx, contextGetToken(r).UserId)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if err = sendJSON(w, tokens, http.StatusOK, nil); err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// deletePersonalToken parses the token id from URL and revokes
+// the token if it belongs to the authenticated user
+func (h *Handler) deletePersonalToken(w http.ResponseWriter, r *http.Request) {
+	tokenId, err := readIdParam(r)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	err = h.store.Token().Delete(ctx, tokenId, contextGetToken(r).UserId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.recordNotFoundResponse(w, r)
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err = sendJSON(w, nil, http.StatusOK, nil); err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// authenticatePersonalToken finds the personal access token, checks
+// whether it has not expired and returns its metadata
+func (h *Handler) authenticatePersonalToken(tokenString string) (*model.TokenMetadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	token, err := h.store.Token().FindByHash(ctx, hashToken(tokenString))
+	if err != nil {
+		return nil, err
+	}
+
+	if token.IsExpired() {
+		return nil, errors.New("token has expired")
+	}
+
+	// Role is taken from the user, so it always reflects the actual one
+	user, err := h.store.User().FindById(ctx, token.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = h.store.Token().Touch(ctx, token.Id); err != nil {
+		h.logError(err)
+	}
+
+	return &model.TokenMetadata{
+		UserId:          user.Id,
+		Role:            user.Role,
+		PersonalTokenId: token.Id,
+		Scopes:          token.Scopes,
+	}, nil
+}
@@ -0,0 +1,479 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-redis/redis/v7"
+	"github.com/gofrs/uuid"
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/juicyluv/astral/internal/totp"
+	"github.com/spf13/viper"
+)
+
+const (
+	// recoveryCodesCount is the number of recovery codes
+	// generated when two-factor authentication is enabled
+	recoveryCodesCount = 10
+
+	// maxTwoFactorAttempts is the number of wrong codes
+	// after which the signin challenge is discarded
+	maxTwoFactorAttempts = 5
+)
+
+var (
+	errTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	errTwoFactorDisabled    = errors.New("two-factor authentication is not enabled")
+	errTwoFactorNotEnrolled = errors.New("two-factor authentication enrollment has not been started")
+	errInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	errInvalidChallenge     = errors.New("invalid or expired challenge token")
+)
+
+// enrollTwoFactor generates a new TOTP secret for the authenticated user
+// and returns it with otpauth URI. Two-factor authentication is not
+// enabled until the user confirms the secret with a code.
+func (h *Handler) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	token := contextGetToken(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	user, err := h.store.User().FindById(ctx, token.UserId)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if user.TotpEnabled {
+		h.badRequestResponse(w, r, errTwoFactorEnabled)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	sealed, err := h.box.Seal([]byte(secret))
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if err = h.store.User().SetTotpSecret(ctx, user.Id, sealed); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	response := jsonResponse{
+		"secret": secret,
+		"uri":    totp.URI(viper.GetString("auth.totpIssuer"), user.Email, secret),
+	}
+
+	if err = sendJSON(w, response, http.StatusOK, nil); err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// confirmTwoFactor checks the first code generated with the enrolled secret
+// and enables two-factor authentication. Returns recovery codes, which
+// are shown to the user only once.
+func (h *Handler) confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var input model.TwoFactorCodeDto
+
+	if err := readJSON(w, r, &input); err != nil {
+		h.invalidRequestBodyResponse(w, r)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	user, err := h.store.User().FindById(ctx, contextGetToken(r).UserId)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if user.TotpEnabled {
+		h.badRequestResponse(w, r, errTwoFactorEnabled)
+		return
+	}
+
+	if user.TotpSecret == nil {
+		h.badRequestResponse(w, r, errTwoFactorNotEnrolled)
+		return
+	}
+
+	ok, err := h.verifyTotpCode(user, input.Code)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		h.badRequestResponse(w, r, errInvalidTwoFactorCode)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if err = h.store.User().EnableTotp(ctx, user.Id, hashes); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if err = sendJSON(w, jsonResponse{"recoveryCodes": codes}, http.StatusOK, nil); err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// disableTwoFactor disables two-factor authentication of the authenticated
+// user. It requires a valid TOTP or recovery code.
+func (h *Handler) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var input model.TwoFactorCodeDto
+
+	if err := readJSON(w, r, &input); err != nil {
+		h.invalidRequestBodyResponse(w, r)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	user, err := h.store.User().FindById(ctx, contextGetToken(r).UserId)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if !user.TotpEnabled {
+		h.badRequestResponse(w, r, errTwoFactorDisabled)
+		return
+	}
+
+	ok, err := h.verifySecondFactor(ctx, user, input.Code)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		h.badRequestResponse(w, r, errInvalidTwoFactorCode)
+		return
+	}
+
+	if err = h.store.User().DisableTotp(ctx, user.Id); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if err = sendJSON(w, nil, http.StatusOK, nil); err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// regenerateRecoveryCodes replaces recovery codes of the authenticated
+// user with new ones. It requires a valid TOTP code.
+func (h *Handler) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var input model.TwoFactorCodeDto
+
+	if err := readJSON(w, r, &input); err != nil {
+		h.invalidRequestBodyResponse(w, r)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	user, err := h.store.User().FindById(ctx, contextGetToken(r).UserId)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if !user.TotpEnabled {
+		h.badRequestResponse(w, r, errTwoFactorDisabled)
+		return
+	}
+
+	ok, err := h.verifyTotpCode(user, input.Code)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		h.badRequestResponse(w, r, errInvalidTwoFactorCode)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if err = h.store.User().ReplaceRecoveryCodes(ctx, user.Id, hashes); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if err = sendJSON(w, jsonResponse{"recoveryCodes": codes}, http.StatusOK, nil); err != nil {
+		h.internalErrorResponse(w, r, err)
+	}
+}
+
+// loginTwoFactor exchanges the challenge token returned by login
+// and a TOTP or recovery code for a new pair of tokens
+func (h *Handler) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var input model.TwoFactorSigninDto
+
+	if err := readJSON(w, r, &input); err != nil {
+		h.invalidRequestBodyResponse(w, r)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	challengeId, userId, err := h.parseTwoFactorChallenge(input.ChallengeToken)
+	if err != nil {
+		h.errorResponse(w, r, http.StatusUnauthorized, errInvalidChallenge.Error())
+		return
+	}
+
+	key := twoFactorChallengeKey(challengeId)
+
+	// Challenge has been used or discarded
+	if err = h.redis.Get(key).Err(); err != nil {
+		if errors.Is(err, redis.Nil) {
+			h.errorResponse(w, r, http.StatusUnauthorized, errInvalidChallenge.Error())
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
+	defer cancel()
+
+	user, err := h.store.User().FindById(ctx, userId)
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			h.errorResponse(w, r, http.StatusUnauthorized, errInvalidChallenge.Error())
+		} else {
+			h.internalErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ok, err := h.verifySecondFactor(ctx, user, input.Code)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		// Discard the challenge after too many wrong codes
+		attempts, err := h.redis.Incr(key).Result()
+		if err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+
+		if attempts >= maxTwoFactorAttempts {
+			if err = h.redis.Del(key).Err(); err != nil {
+				h.internalErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		h.errorResponse(w, r, http.StatusUnauthorized, errInvalidTwoFactorCode.Error())
+		return
+	}
+
+	// Only the request which actually deleted the challenge may use it
+	deleted, err := h.redis.Del(key).Result()
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	if deleted == 0 {
+		h.errorResponse(w, r, http.StatusUnauthorized, errInvalidChallenge.Error())
+		return
+	}
+
+	h.sendTokenPair(w, r, user)
+}
+
+// twoFactorChallengeKey returns the cache key of the signin challenge.
+// The value is the number of failed attempts.
+func twoFactorChallengeKey(challengeId string) string {
+	return "two_factor_challenge:" + challengeId
+}
+
+// createTwoFactorChallenge creates a short-lived single-use token
+// which proves that the user has entered a valid password
+func (h *Handler) createTwoFactorChallenge(userId int) (string, error) {
+	expTime := time.Duration(viper.GetInt("auth.twoFactorChallengeExpTime")) * time.Minute
+
+	challengeId, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{}
+	claims["token_type"] = tokenTypeTwoFactor
+	claims["user_id"] = userId
+	claims["challenge_id"] = challengeId.String()
+	claims["exp"] = time.Now().Add(expTime).Unix()
+
+	token, err := h.keys.Sign(claims)
+	if err != nil {
+		return "", err
+	}
+
+	err = h.redis.Set(twoFactorChallengeKey(challengeId.String()), 0, expTime).Err()
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// parseTwoFactorChallenge verifies the challenge token
+// and returns the challenge id and the user id
+func (h *Handler) parseTwoFactorChallenge(tokenString string) (string, int, error) {
+	errInvalidToken := errors.New("invalid token")
+
+	token, err := h.keys.Parse(tokenString)
+	if err != nil {
+		return "", 0, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || !hasTokenType(claims, tokenTypeTwoFactor) {
+		return "", 0, errInvalidToken
+	}
+
+	challengeId, ok := claims["challenge_id"].(string)
+	if !ok {
+		return "", 0, errInvalidToken
+	}
+
+	userId, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+	if err != nil {
+		return "", 0, errInvalidToken
+	}
+
+	return challengeId, int(userId), nil
+}
+
+// verifySecondFactor checks whether the code is a valid TOTP code
+// or an unused recovery code of the user. Recovery code is marked
+// as used on success.
+func (h *Handler) verifySecondFactor(ctx context.Context, user *model.User, code string) (bool, error) {
+	if isTotpCode(code) {
+		return h.verifyTotpCode(user, code)
+	}
+
+	err := h.store.User().UseRecoveryCode(ctx, user.Id, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		if errors.Is(err, errNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// verifyTotpCode checks the code against the user secret. Every code
+// is accepted only once, so an intercepted code can't be replayed.
+func (h *Handler) verifyTotpCode(user *model.User, code string) (bool, error) {
+	if user.TotpSecret == nil || !isTotpCode(code) {
+		return false, nil
+	}
+
+	secret, err := h.box.Open(user.TotpSecret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(string(secret), code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// Remember the used step while its codes are still accepted
+	key := fmt.Sprintf("user:%d:totp_step:%d", user.Id, step)
+	ttl := time.Duration((2*totp.Skew+1)*totp.Period) * time.Second
+
+	return h.redis.SetNX(key, 1, ttl).Result()
+}
+
+// isTotpCode reports whether the code looks like a TOTP code
+func isTotpCode(code string) bool {
+	if len(code) != totp.Digits {
+		return false
+	}
+	_, err := strconv.Atoi(code)
+	return err == nil
+}
+
+// generateRecoveryCodes returns new recovery codes
+// and their hashes which are stored in database
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodesCount)
+	hashes := make([]string, recoveryCodesCount)
+
+	for i := range codes {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(secret[:10])
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode removes formatting from the recovery code
+// entered by the user
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
@@ -1,17 +1,14 @@
 package handler
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"net/http"
-	"text/template"
+	"strings"
 
-	"github.com/juicyluv/astral/internal/mail"
+	"github.com/juicyluv/astral/internal/handler/filter"
 	"github.com/juicyluv/astral/internal/model"
-	"github.com/spf13/viper"
-	"go.uber.org/zap"
+	"github.com/juicyluv/astral/internal/store"
 )
 
 // createUser will parse request body and create the user record.
@@ -33,16 +30,16 @@ func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
 	defer cancel()
 
-	found, err := h.store.User().FindByEmail(ctx, user.Email)
-	if err != nil {
-		if err != errNoRows {
-			if found != nil {
-				h.badRequestResponse(w, r, errors.New("email already taken"))
-			} else {
-				h.internalErrorResponse(w, r, err)
-			}
-			return
-		}
+	user.Email = strings.ToLower(user.Email)
+
+	_, err = h.store.User().FindByEmail(ctx, user.Email)
+	if err == nil {
+		h.badRequestResponse(w, r, store.ErrEmailTaken)
+		return
+	}
+	if !errors.Is(err, errNoRows) {
+		h.internalErrorResponse(w, r, err)
+		return
 	}
 
 	if err = user.HashPassword(); err != nil {
@@ -56,50 +53,12 @@ func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.createEmailToken(userId)
-	if err != nil {
+	user.Id = userId
+	if err = h.sendConfirmationEmail(&user); err != nil {
 		h.internalErrorResponse(w, r, errors.New("could not create email token"))
 		return
 	}
 
-	// Send email message to the user
-	go func(logger *zap.SugaredLogger, username, email, token string) {
-		subject := viper.GetString("mail.subject")
-		filepath := "./internal/mail/templates/confirm_request.html"
-		t := template.Must(template.ParseFiles(filepath))
-
-		b := []byte{}
-		buf := bytes.NewBuffer(b)
-		t.ExecuteTemplate(buf, "confirm_request.html", struct {
-			Username    string
-			ConfirmLink string
-		}{
-			Username:    username,
-			ConfirmLink: "http://localhost:8080/api/confirmation?token=" + token,
-		})
-
-		var messageBuffer bytes.Buffer
-		encoder := json.NewEncoder(&messageBuffer)
-		err := encoder.Encode(mail.Message{
-			EmailTo: email,
-			Subject: subject,
-			Mime:    mail.MimeHTML,
-			Message: buf.Bytes(),
-		})
-		if err != nil {
-			logger.Error(err)
-			return
-		}
-
-		err = h.queue.Dispatch(messageBuffer.Bytes())
-		if err != nil {
-			logger.Errorf("could not send message to the queue: %v", err)
-			return
-		}
-
-		logger.Infof("email was sent to %s", email)
-	}(h.logger, user.Username, user.Email, token)
-
 	err = sendJSON(w, jsonResponse{"id": userId}, http.StatusOK, nil)
 	if err != nil {
 		h.internalErrorResponse(w, r, err)
@@ -148,7 +107,13 @@ func (h *Handler) getUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = sendJSON(w, user, http.StatusOK, nil)
+	user.Follows, err = h.store.Follow().Counts(ctx, user.Id)
+	if err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendJSON(w, user, http.StatusOK, versionHeaders(user.Version))
 	if err != nil {
 		h.internalErrorResponse(w, r, err)
 	}
@@ -165,6 +130,18 @@ func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	version, ok := readIfMatch(r)
+	if !ok {
+		h.preconditionFailedResponse(w, r)
+		return
+	}
+
+	token := contextGetToken(r)
+	if !canModifyUser(token, userId) {
+		h.forbiddenResponse(w, r)
+		return
+	}
+
 	var user model.UpdateUserDto
 
 	if err := readJSON(w, r, &user); err != nil {
@@ -176,17 +153,61 @@ func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request) {
 		h.errorResponse(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
+	user.Version = version
+
+	if user.Role != nil && !canChangeRole(token) {
+		h.forbiddenResponse(w, r)
+		return
+	}
+
+	// Users verify emails only by the confirmation links
+	if user.IsVerified != nil && !canChangeVerification(token) {
+		h.forbiddenResponse(w, r)
+		return
+	}
+
+	// Personal access tokens must not be able to take over the account
+	if user.Password != nil && token.IsPersonalToken() {
+		h.forbiddenResponse(w, r)
+		return
+	}
+
+	if user.Password != nil {
+		hashed := model.User{Password: *user.Password}
+		if err = hashed.HashPassword(); err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+		user.Password = &hashed.Password
+	}
 
 	err = h.store.User().Update(ctx, int(userId), &user)
 	if err != nil {
-		if errors.Is(err, errNoRows) {
+		switch {
+		case errors.Is(err, errNoRows):
 			h.recordNotFoundResponse(w, r)
-		} else {
+		case errors.Is(err, store.ErrVersionConflict):
+			h.preconditionFailedResponse(w, r)
+		default:
 			h.internalErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	// Password has been changed, so close every other session of the user.
+	// The current session is kept only if the user changes their own password.
+	if user.Password != nil {
+		keep := ""
+		if token.UserId == userId {
+			keep = token.FamilyId
+		}
+
+		if err = h.revokeUserSessions(userId, keep); err != nil {
+			h.internalErrorResponse(w, r, err)
+			return
+		}
+	}
+
 	err = sendJSON(w, nil, http.StatusOK, nil)
 	if err != nil {
 		h.internalErrorResponse(w, r, err)
@@ -204,6 +225,11 @@ func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !canModifyUser(contextGetToken(r), userId) {
+		h.forbiddenResponse(w, r)
+		return
+	}
+
 	err = h.store.User().Delete(ctx, int(userId))
 	if err != nil {
 		if errors.Is(err, errNoRows) {
@@ -214,6 +240,11 @@ func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err = h.revokeUserSessions(userId, ""); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
 	err = sendJSON(w, nil, http.StatusOK, nil)
 	if err != nil {
 		h.internalErrorResponse(w, r, err)
@@ -221,7 +252,8 @@ func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
 }
 
 // listUserPosts will parse user id from URL query parameters
-// and return posts which belong to this user
+// and return a page of posts which belong to this user. Posts are
+// filtered the same way as in listPost.
 func (h *Handler) listUserPosts(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), h.requestTimeout)
 	defer cancel()
@@ -232,6 +264,16 @@ func (h *Handler) listUserPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	filter, err := filter.NewPostFilter(r.URL.Query())
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	if token, ok := contextFindToken(r); ok {
+		filter.ViewerId = token.UserId
+	}
+
 	_, err = h.store.User().FindById(ctx, userId)
 	if err != nil {
 		if errors.Is(err, errNoRows) {
@@ -242,7 +284,7 @@ func (h *Handler) listUserPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := h.store.Post().FindUserPosts(ctx, userId)
+	posts, next, err := h.store.Post().FindUserPosts(ctx, userId, filter)
 	if err != nil {
 		if errors.Is(err, errNoRows) {
 			h.recordNotFoundResponse(w, r)
@@ -252,7 +294,12 @@ func (h *Handler) listUserPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = sendJSON(w, posts, http.StatusOK, nil)
+	if err = h.setMyPostReactions(ctx, r, postPointers(posts)); err != nil {
+		h.internalErrorResponse(w, r, err)
+		return
+	}
+
+	err = sendPage(w, r, "posts", posts, next.Encode())
 	if err != nil {
 		h.internalErrorResponse(w, r, err)
 	}
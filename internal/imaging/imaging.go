@@ -0,0 +1,113 @@
+// Package imaging decodes uploaded images and makes thumbnails of them
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/png"
+
+	// Decoders of the supported formats
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+// MaxPixels limits the dimensions of decoded images, so small
+// files can't take lots of memory when they are decoded
+const MaxPixels = 4096 * 4096
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooLarge          = errors.New("image dimensions are too large")
+)
+
+// Decode decodes the image. Dimensions are checked before the image is decoded.
+func Decode(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	return img, nil
+}
+
+// Thumbnail crops the center square of the image and scales it to the
+// size. Every thumbnail pixel is the average of the source pixels it
+// covers, which keeps downscaled images smooth.
+func Thumbnail(img image.Image, size int) *image.NRGBA {
+	bounds := img.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+
+	// Source is converted once, so pixels are read without interface calls
+	src := image.NewNRGBA(image.Rect(0, 0, side, side))
+	offset := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+	draw.Draw(src, src.Bounds(), img, offset, draw.Src)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+
+	for dy := 0; dy < size; dy++ {
+		y0, y1 := span(dy, side, size)
+
+		for dx := 0; dx < size; dx++ {
+			x0, x1 := span(dx, side, size)
+
+			// Colors are weighted by alpha, so transparent pixels don't darken edges
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride:]
+				for x := x0; x < x1; x++ {
+					p := row[x*4 : x*4+4]
+					alpha := uint64(p[3])
+					r += uint64(p[0]) * alpha
+					g += uint64(p[1]) * alpha
+					b += uint64(p[2]) * alpha
+					a += alpha
+					n++
+				}
+			}
+
+			i := dst.PixOffset(dx, dy)
+			if a > 0 {
+				dst.Pix[i] = uint8(r / a)
+				dst.Pix[i+1] = uint8(g / a)
+				dst.Pix[i+2] = uint8(b / a)
+			}
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// span returns the range of source pixels which the thumbnail pixel covers.
+// The range is never empty, so images smaller than the thumbnail are enlarged.
+func span(i, side, size int) (int, int) {
+	start := i * side / size
+	end := (i + 1) * side / size
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
+
+// EncodePNG encodes the image as PNG
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,15 @@
+package keys
+
+import "github.com/spf13/viper"
+
+type Config struct {
+	Dir         string
+	ActiveKeyId string
+}
+
+func NewConfig() *Config {
+	return &Config{
+		Dir:         viper.GetString("auth.keysDir"),
+		ActiveKeyId: viper.GetString("auth.activeKeyId"),
+	}
+}
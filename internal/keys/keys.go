@@ -0,0 +1,168 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// minRSAKeyBits is the minimal size of RSA keys accepted for signing tokens
+const minRSAKeyBits = 2048
+
+var (
+	ErrUnknownKey     = errors.New("unknown signing key")
+	ErrUnexpectedAlg  = errors.New("unexpected token signing method")
+	ErrNoActiveKey    = errors.New("active signing key is not configured")
+	ErrNoKeysLoaded   = errors.New("no signing keys found")
+	ErrUnsupportedKey = errors.New("unsupported key type")
+)
+
+// Key is a signing key loaded from disk. Retired keys may be
+// loaded without the private part, they are used only to verify tokens.
+type Key struct {
+	Id        string
+	Method    jwt.SigningMethod
+	Private   crypto.Signer
+	PublicKey crypto.PublicKey
+}
+
+// KeyManager signs tokens with the active key and verifies
+// tokens signed by any loaded key, so keys can be rotated without
+// invalidating tokens which were issued before the rotation.
+type KeyManager struct {
+	keys   map[string]*Key
+	active *Key
+}
+
+// NewKeyManager loads every *.pem file from the keys directory.
+// The file name without extension is used as the key id. It returns
+// an error if there are no keys or the active key has no private part.
+func NewKeyManager(cfg *Config) (*KeyManager, error) {
+	if cfg.ActiveKeyId == "" {
+		return nil, ErrNoActiveKey
+	}
+
+	files, err := filepath.Glob(filepath.Join(cfg.Dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	m := KeyManager{keys: make(map[string]*Key)}
+
+	for _, file := range files {
+		key, err := loadKey(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not load key %s: %w", file, err)
+		}
+		m.keys[key.Id] = key
+	}
+
+	if len(m.keys) == 0 {
+		return nil, fmt.Errorf("%w in %q", ErrNoKeysLoaded, cfg.Dir)
+	}
+
+	active, ok := m.keys[cfg.ActiveKeyId]
+	if !ok || active.Private == nil {
+		return nil, fmt.Errorf("%w: private key %q not found", ErrNoActiveKey, cfg.ActiveKeyId)
+	}
+	m.active = active
+
+	return &m, nil
+}
+
+// Sign signs the claims with the active key. Key id is put
+// into the token header, so the verifier knows which key to use.
+func (m *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(m.active.Method, claims)
+	token.Header["kid"] = m.active.Id
+
+	return token.SignedString(m.active.Private)
+}
+
+// Parse parses the token and verifies its signature
+// with the key mentioned in the token header.
+func (m *KeyManager) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, m.keyFunc)
+}
+
+func (m *KeyManager) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	key, ok := m.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	// Never let the token choose the algorithm
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, ErrUnexpectedAlg
+	}
+
+	return key.PublicKey, nil
+}
+
+// loadKey reads the PEM encoded private or public key from the file.
+func loadKey(file string) (*Key, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM data")
+	}
+
+	key := Key{Id: strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))}
+
+	var parsed interface{}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKey, block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.Private = signer
+		parsed = signer.Public()
+	}
+
+	switch publicKey := parsed.(type) {
+	case *rsa.PublicKey:
+		if publicKey.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA key must be at least %d bits", minRSAKeyBits)
+		}
+		key.Method = jwt.SigningMethodRS256
+		key.PublicKey = publicKey
+	case ed25519.PublicKey:
+		key.Method = SigningMethodEdDSA
+		key.PublicKey = publicKey
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, publicKey)
+	}
+
+	return &key, nil
+}
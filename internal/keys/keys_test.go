@@ -0,0 +1,306 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// testKeys are the keys written to the keys directory of the test
+type testKeys struct {
+	dir     string
+	rsa     *rsa.PrivateKey
+	ed      ed25519.PrivateKey
+	retired ed25519.PrivateKey
+}
+
+// newTestKeys writes an RSA and an Ed25519 private key and a public
+// part of a retired Ed25519 key to a temporary keys directory
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, retiredKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := &testKeys{dir: t.TempDir(), rsa: rsaKey, ed: edKey, retired: retiredKey}
+
+	writePEM(t, filepath.Join(k.dir, "rsa.pem"), "PRIVATE KEY", marshalPKCS8(t, rsaKey))
+	writePEM(t, filepath.Join(k.dir, "ed.pem"), "PRIVATE KEY", marshalPKCS8(t, edKey))
+
+	public, err := x509.MarshalPKIXPublicKey(retiredKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(k.dir, "retired.pem"), "PUBLIC KEY", public)
+
+	return k
+}
+
+func (k *testKeys) manager(t *testing.T, activeKeyId string) *KeyManager {
+	t.Helper()
+
+	m, err := NewKeyManager(&Config{Dir: k.dir, ActiveKeyId: activeKeyId})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func marshalPKCS8(t *testing.T, key interface{}) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return der
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sign signs the claims with the method and the key and puts the kid into the header
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "1"})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+// keyFuncError returns the error of the key selection of the failed parse
+func keyFuncError(err error) error {
+	if ve, ok := err.(*jwt.ValidationError); ok && ve.Inner != nil {
+		return ve.Inner
+	}
+	return err
+}
+
+func TestSignParse(t *testing.T) {
+	k := newTestKeys(t)
+
+	for _, tt := range []struct {
+		activeKeyId string
+		alg         string
+	}{
+		{"rsa", "RS256"},
+		{"ed", "EdDSA"},
+	} {
+		t.Run(tt.alg, func(t *testing.T) {
+			m := k.manager(t, tt.activeKeyId)
+
+			signed, err := m.Sign(jwt.MapClaims{"sub": "1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := m.Parse(signed)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if token.Header["kid"] != tt.activeKeyId || token.Method.Alg() != tt.alg {
+				t.Errorf("unexpected header %v", token.Header)
+			}
+
+			if claims, _ := token.Claims.(jwt.MapClaims); claims["sub"] != "1" {
+				t.Errorf("unexpected claims %v", token.Claims)
+			}
+		})
+	}
+}
+
+func TestParseRotatedKeys(t *testing.T) {
+	k := newTestKeys(t)
+
+	// Tokens signed by the previous active key are still valid
+	signed, err := k.manager(t, "rsa").Sign(jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := k.manager(t, "ed")
+	if _, err := m.Parse(signed); err != nil {
+		t.Errorf("token of the previous key is rejected: %v", err)
+	}
+
+	// Retired keys without private part verify tokens only
+	if _, err := m.Parse(sign(t, SigningMethodEdDSA, "retired", k.retired)); err != nil {
+		t.Errorf("token of the retired key is rejected: %v", err)
+	}
+}
+
+func TestParseRejected(t *testing.T) {
+	k := newTestKeys(t)
+	m := k.manager(t, "ed")
+
+	rsaPublic, err := x509.MarshalPKIXPublicKey(k.rsa.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	none := sign(t, jwt.SigningMethodNone, "ed", jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{
+			name:  "RS256 token for EdDSA key",
+			token: sign(t, jwt.SigningMethodRS256, "ed", k.rsa),
+			err:   ErrUnexpectedAlg,
+		},
+		{
+			name:  "EdDSA token for RS256 key",
+			token: sign(t, SigningMethodEdDSA, "rsa", k.ed),
+			err:   ErrUnexpectedAlg,
+		},
+		{
+			name:  "HS256 token signed with the public key",
+			token: sign(t, jwt.SigningMethodHS256, "rsa", rsaPublic),
+			err:   ErrUnexpectedAlg,
+		},
+		{
+			name:  "none algorithm",
+			token: none,
+			err:   ErrUnexpectedAlg,
+		},
+		{
+			name:  "unknown kid",
+			token: sign(t, SigningMethodEdDSA, "other", k.ed),
+			err:   ErrUnknownKey,
+		},
+		{
+			name:  "missing kid",
+			token: sign(t, SigningMethodEdDSA, "", k.ed),
+			err:   ErrUnknownKey,
+		},
+		{
+			name:  "signed by another key",
+			token: sign(t, SigningMethodEdDSA, "ed", k.retired),
+			err:   jwt.ErrSignatureInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Parse(tt.token)
+			if keyFuncError(err) != tt.err {
+				t.Errorf("Parse() returned %v, expected %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewKeyManagerErrors(t *testing.T) {
+	k := newTestKeys(t)
+
+	if _, err := NewKeyManager(&Config{Dir: k.dir}); err != ErrNoActiveKey {
+		t.Errorf("expected ErrNoActiveKey without the active key id, got %v", err)
+	}
+
+	if _, err := NewKeyManager(&Config{Dir: k.dir, ActiveKeyId: "retired"}); err == nil {
+		t.Error("the key without private part is accepted as the active key")
+	}
+
+	if _, err := NewKeyManager(&Config{Dir: t.TempDir(), ActiveKeyId: "rsa"}); err == nil {
+		t.Error("the empty keys directory is accepted")
+	}
+
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(k.dir, "weak.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(weak))
+
+	if _, err := NewKeyManager(&Config{Dir: k.dir, ActiveKeyId: "rsa"}); err == nil {
+		t.Error("the 1024 bit RSA key is accepted")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	k := newTestKeys(t)
+	set := k.manager(t, "rsa").JWKS()
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	// Members of private RSA (d, p, q, dp, dq, qi) and OKP (d) keys
+	for _, jwk := range raw.Keys {
+		for _, name := range []string{"d", "p", "q", "dp", "dq", "qi"} {
+			if _, ok := jwk[name]; ok {
+				t.Errorf("key %v has private member %s", jwk["kid"], name)
+			}
+		}
+	}
+
+	if len(set.Keys) != 3 {
+		t.Fatalf("expected 3 keys, got %d", len(set.Keys))
+	}
+
+	expected := map[string]JSONWebKey{
+		"ed": {
+			Kty: "OKP", Kid: "ed", Alg: "EdDSA", Use: "sig", Crv: "Ed25519",
+			X: encodeBase64(k.ed.Public().(ed25519.PublicKey)),
+		},
+		"retired": {
+			Kty: "OKP", Kid: "retired", Alg: "EdDSA", Use: "sig", Crv: "Ed25519",
+			X: encodeBase64(k.retired.Public().(ed25519.PublicKey)),
+		},
+		"rsa": {
+			Kty: "RSA", Kid: "rsa", Alg: "RS256", Use: "sig",
+			N: encodeBase64(k.rsa.N.Bytes()), E: "AQAB",
+		},
+	}
+
+	for _, jwk := range set.Keys {
+		if jwk != expected[jwk.Kid] {
+			t.Errorf("unexpected key %+v", jwk)
+		}
+	}
+}
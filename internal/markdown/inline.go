@@ -0,0 +1,480 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// markupChars are the characters which may start inline markup
+const markupChars = "\\`*_~![<h& "
+
+// Lengths of link parts are limited, so unclosed brackets
+// don't make every following bracket scan the whole text
+const (
+	maxLinkTextLength        = 1000
+	maxLinkDestinationLength = 2048
+)
+
+var (
+	entityPattern   = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[a-zA-Z][a-zA-Z0-9]{1,31});`)
+	autolinkPattern = regexp.MustCompile(`^<((?:https?://|mailto:)[^\s<>]*|[a-zA-Z0-9.!#$%&'*+/=?^_{|}~-]+@[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)+)>`)
+	bareUrlPattern  = regexp.MustCompile(`^https?://[^\s<>"]+`)
+
+	trailingEntityPattern = regexp.MustCompile(`&[a-zA-Z0-9#]+;$`)
+)
+
+// renderInline renders inline markdown of the text: code spans, emphasis,
+// strikethrough, links, images and line breaks. Links can't be nested,
+// so links are not rendered inside link text.
+func renderInline(sb *strings.Builder, text string, inLink bool) {
+	closers := findClosers(text)
+
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		switch c {
+		case '\\':
+			if i+1 < len(text) && text[i+1] == '\n' {
+				sb.WriteString("<br>\n")
+				i += 2
+				continue
+			}
+			if i+1 < len(text) && isPunct(text[i+1]) {
+				sb.WriteString(html.EscapeString(text[i+1 : i+2]))
+				i += 2
+				continue
+			}
+		case '`':
+			n := runLength(text, i)
+			if code, end, ok := codeSpan(text, i, n); ok {
+				sb.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i = end
+				continue
+			}
+			sb.WriteString(text[i : i+n])
+			i += n
+			continue
+		case '*', '_', '~':
+			n := runLength(text, i)
+			if end, ok := renderDelimited(sb, text, i, n, closers, inLink); ok {
+				i = end
+				continue
+			}
+			sb.WriteString(text[i : i+n])
+			i += n
+			continue
+		case '!':
+			if i+1 < len(text) && text[i+1] == '[' {
+				if label, dest, title, end, ok := parseLink(text, i+1); ok {
+					sb.WriteString(`<img src="` + html.EscapeString(dest) + `" alt="` + html.EscapeString(plainText(label)) + `"`)
+					if title != "" {
+						sb.WriteString(` title="` + html.EscapeString(title) + `"`)
+					}
+					sb.WriteString(">")
+					i = end
+					continue
+				}
+			}
+		case '[':
+			if inLink {
+				break
+			}
+			if label, dest, title, end, ok := parseLink(text, i); ok {
+				sb.WriteString(`<a href="` + html.EscapeString(dest) + `"`)
+				if title != "" {
+					sb.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				sb.WriteString(">")
+				renderInline(sb, label, true)
+				sb.WriteString("</a>")
+				i = end
+				continue
+			}
+		case '<':
+			if m := autolinkPattern.FindStringSubmatch(text[i:]); m != nil && !inLink {
+				href := m[1]
+				if !strings.Contains(href, ":") {
+					href = "mailto:" + href
+				}
+				sb.WriteString(`<a href="` + html.EscapeString(href) + `">` + html.EscapeString(m[1]) + "</a>")
+				i += len(m[0])
+				continue
+			}
+		case 'h':
+			if inLink || !strings.HasPrefix(text[i:], "http") {
+				break
+			}
+			if url := bareUrl(text, i); url != "" {
+				escaped := html.EscapeString(html.UnescapeString(url))
+				sb.WriteString(`<a href="` + escaped + `">` + escaped + "</a>")
+				i += len(url)
+				continue
+			}
+		case '&':
+			if entity := entityPattern.FindString(text[i:]); entity != "" {
+				sb.WriteString(html.EscapeString(html.UnescapeString(entity)))
+				i += len(entity)
+				continue
+			}
+		case ' ':
+			// Trailing spaces are dropped, two of them make a line break
+			n := runLength(text, i)
+			if i+n < len(text) && text[i+n] == '\n' {
+				if n >= 2 {
+					sb.WriteString("<br>")
+				}
+				i += n
+				continue
+			}
+			sb.WriteString(text[i : i+n])
+			i += n
+			continue
+		}
+
+		// Text up to the next character which may start markup is written as is
+		j := i + 1
+		for j < len(text) && strings.IndexByte(markupChars, text[j]) < 0 {
+			j++
+		}
+		sb.WriteString(html.EscapeString(text[i:j]))
+		i = j
+	}
+}
+
+// delimiterRun is a run of emphasis or strikethrough
+// delimiters which is able to close the emphasis
+type delimiterRun struct {
+	pos, length int
+}
+
+// findClosers finds delimiter runs of the text which are able to close
+// emphasis. Runs inside code spans and escaped delimiters are skipped.
+func findClosers(text string) map[byte][]delimiterRun {
+	closers := make(map[byte][]delimiterRun)
+
+	for i := 0; i < len(text); {
+		switch c := text[i]; c {
+		case '\\':
+			i += 2
+		case '`':
+			n := runLength(text, i)
+			if _, end, ok := codeSpan(text, i, n); ok {
+				i = end
+			} else {
+				i += n
+			}
+		case '*', '_', '~':
+			n := runLength(text, i)
+			if canClose(text, i, n) {
+				closers[c] = append(closers[c], delimiterRun{pos: i, length: n})
+			}
+			i += n
+		default:
+			i++
+		}
+	}
+
+	return closers
+}
+
+// renderDelimited renders emphasis, strong emphasis or strikethrough
+// opened by the delimiter run of length n at i and returns the index
+// after the closing run. It returns false when the run doesn't open
+// anything or it isn't closed. Runs of three open the emphasis, so
+// the strong emphasis is rendered inside it.
+func renderDelimited(sb *strings.Builder, text string, i, n int, closers map[byte][]delimiterRun, inLink bool) (int, bool) {
+	c := text[i]
+	if !canOpen(text, i, n) {
+		return 0, false
+	}
+
+	size := 1
+	switch {
+	case c == '~' && n != 2:
+		return 0, false
+	case c == '~' || n == 2:
+		size = 2
+	}
+
+	// Closers are sorted by position, so the search starts after the opening run
+	runs := closers[c]
+	first := sort.Search(len(runs), func(k int) bool {
+		return runs[k].pos >= i+n
+	})
+
+	for _, closer := range runs[first:] {
+		// Longer runs close both emphasis and strong emphasis
+		if closer.length != size && (c == '~' || closer.length < 3) {
+			continue
+		}
+
+		start, end := i+size, closer.pos+closer.length-size
+		if start >= end {
+			continue
+		}
+
+		tag := "em"
+		switch {
+		case c == '~':
+			tag = "del"
+		case size == 2:
+			tag = "strong"
+		}
+
+		sb.WriteString("<" + tag + ">")
+		renderInline(sb, text[start:end], inLink)
+		sb.WriteString("</" + tag + ">")
+
+		return closer.pos + closer.length, true
+	}
+
+	return 0, false
+}
+
+// canOpen reports whether the delimiter run can open emphasis. It must be
+// followed by a non-space character, underscores can't open it inside words.
+func canOpen(text string, i, n int) bool {
+	next, _ := utf8.DecodeRuneInString(text[i+n:])
+	if i+n >= len(text) || unicode.IsSpace(next) {
+		return false
+	}
+
+	if text[i] == '_' && i > 0 {
+		prev, _ := utf8.DecodeLastRuneInString(text[:i])
+		return !isWordRune(prev)
+	}
+
+	return true
+}
+
+// canClose reports whether the delimiter run can close emphasis. It must be
+// preceded by a non-space character, underscores can't close it inside words.
+func canClose(text string, i, n int) bool {
+	if i == 0 {
+		return false
+	}
+
+	prev, _ := utf8.DecodeLastRuneInString(text[:i])
+	if unicode.IsSpace(prev) {
+		return false
+	}
+
+	if text[i] == '_' && i+n < len(text) {
+		next, _ := utf8.DecodeRuneInString(text[i+n:])
+		return !isWordRune(next)
+	}
+
+	return true
+}
+
+// codeSpan returns the content of the code span opened by the backtick
+// run of length n at i and the index after it. Line breaks in the code
+// are spaces, a single space around the code is stripped.
+func codeSpan(text string, i, n int) (string, int, bool) {
+	for j := i + n; j < len(text); {
+		k := strings.IndexByte(text[j:], '`')
+		if k < 0 {
+			return "", 0, false
+		}
+		j += k
+
+		m := runLength(text, j)
+		if m != n {
+			j += m
+			continue
+		}
+
+		code := strings.ReplaceAll(text[i+n:j], "\n", " ")
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+			code = code[1 : len(code)-1]
+		}
+
+		return code, j + n, true
+	}
+
+	return "", 0, false
+}
+
+// parseLink parses the inline link [label](destination "title") starting
+// with the bracket at i and returns its parts and the index after it
+func parseLink(text string, i int) (label, dest, title string, end int, ok bool) {
+	labelEnd := -1
+	depth := 0
+
+loop:
+	for j := i; j < len(text) && j-i <= maxLinkTextLength; j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '`':
+			n := runLength(text, j)
+			if _, codeEnd, ok := codeSpan(text, j, n); ok {
+				j = codeEnd - 1
+			} else {
+				j += n - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				labelEnd = j
+				break loop
+			}
+		}
+	}
+
+	if labelEnd < 0 || labelEnd+1 >= len(text) || text[labelEnd+1] != '(' {
+		return "", "", "", 0, false
+	}
+
+	j := skipSpaces(text, labelEnd+2)
+
+	// The destination is either wrapped in angle brackets
+	// or has no spaces and balanced parentheses
+	destStart := j
+	if j < len(text) && text[j] == '<' {
+		k := strings.IndexAny(text[j+1:], "<>\n")
+		if k < 0 || text[j+1+k] != '>' {
+			return "", "", "", 0, false
+		}
+		dest = text[j+1 : j+1+k]
+		j += k + 2
+	} else {
+		parens := 0
+	destination:
+		for ; j < len(text) && j-destStart <= maxLinkDestinationLength; j++ {
+			switch c := text[j]; {
+			case c == '\\' && j+1 < len(text) && isPunct(text[j+1]):
+				j++
+			case c == '(':
+				parens++
+			case c == ')':
+				if parens == 0 {
+					break destination
+				}
+				parens--
+			case c <= ' ':
+				break destination
+			}
+		}
+		dest = text[destStart:j]
+	}
+
+	k := skipSpaces(text, j)
+	if k < len(text) && k > j && (text[k] == '"' || text[k] == '\'' || text[k] == '(') {
+		closing := text[k]
+		if closing == '(' {
+			closing = ')'
+		}
+
+		titleEnd := -1
+		for t := k + 1; t < len(text) && t-k <= maxLinkTextLength; t++ {
+			if text[t] == '\\' {
+				t++
+				continue
+			}
+			if text[t] == closing {
+				titleEnd = t
+				break
+			}
+		}
+		if titleEnd < 0 {
+			return "", "", "", 0, false
+		}
+
+		title = unescape(text[k+1 : titleEnd])
+		k = skipSpaces(text, titleEnd+1)
+	}
+
+	if k >= len(text) || text[k] != ')' {
+		return "", "", "", 0, false
+	}
+
+	return text[i+1 : labelEnd], unescape(dest), title, k + 1, true
+}
+
+// bareUrl returns the web address starting at i. Trailing punctuation, entity
+// references and unbalanced closing parentheses don't belong to the address.
+func bareUrl(text string, i int) string {
+	if i > 0 {
+		prev, _ := utf8.DecodeLastRuneInString(text[:i])
+		if isWordRune(prev) {
+			return ""
+		}
+	}
+
+	url := bareUrlPattern.FindString(text[i:])
+	for url != "" {
+		if entity := trailingEntityPattern.FindString(url); entity != "" {
+			url = url[:len(url)-len(entity)]
+			continue
+		}
+
+		last := url[len(url)-1]
+		if strings.IndexByte(".,:;!?'*_~", last) >= 0 ||
+			(last == ')' && strings.Count(url, "(") < strings.Count(url, ")")) {
+			url = url[:len(url)-1]
+			continue
+		}
+		break
+	}
+
+	if len(url) <= len("https://") {
+		return ""
+	}
+
+	return url
+}
+
+// plainText returns the text of the inline markdown without the markup,
+// it is used for alternative text of images
+func plainText(text string) string {
+	var sb strings.Builder
+	renderInline(&sb, text, true)
+	return html.UnescapeString(stripTags(sb.String()))
+}
+
+// unescape replaces backslash escapes and entity references with the characters
+func unescape(text string) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) && isPunct(text[i+1]) {
+			i++
+		}
+		sb.WriteByte(text[i])
+	}
+
+	return html.UnescapeString(sb.String())
+}
+
+// runLength returns the length of the run of the character at i
+func runLength(text string, i int) int {
+	n := 1
+	for i+n < len(text) && text[i+n] == text[i] {
+		n++
+	}
+	return n
+}
+
+func skipSpaces(text string, i int) int {
+	for i < len(text) && (text[i] == ' ' || text[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// isPunct reports whether the character is ASCII punctuation,
+// which can be escaped with a backslash
+func isPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
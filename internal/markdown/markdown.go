@@ -0,0 +1,409 @@
+// Package markdown renders post content to HTML. Markdown is a subset
+// of CommonMark with strikethrough and links to bare URLs, raw HTML in
+// the source is escaped. Rendered HTML is sanitized, so it is safe to
+// show it as is.
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	headingPattern = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))??(?:[ \t]+#+)?[ \t]*$`)
+	setextPattern  = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	fencePattern   = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^`]*?)[ \t]*$")
+	breakPattern   = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	quotePattern   = regexp.MustCompile(`^ {0,3}> ?`)
+	listPattern    = regexp.MustCompile(`^( {0,3})([-*+]|[0-9]{1,9}[.)])([ \t]+|$)`)
+)
+
+// Render renders the markdown source to sanitized HTML
+func Render(source string) string {
+	var sb strings.Builder
+	renderBlocks(&sb, splitLines(source), false)
+	return Sanitize(sb.String())
+}
+
+// RenderPlain renders the plain text to sanitized HTML. Paragraphs
+// are separated by blank lines, line breaks inside them are kept.
+func RenderPlain(text string) string {
+	var sb strings.Builder
+	var paragraph []string
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			sb.WriteString("<p>" + strings.Join(paragraph, "<br>\n") + "</p>\n")
+			paragraph = paragraph[:0]
+		}
+	}
+
+	for _, line := range splitLines(text) {
+		if isBlank(line) {
+			flush()
+			continue
+		}
+		paragraph = append(paragraph, html.EscapeString(strings.TrimSpace(line)))
+	}
+	flush()
+
+	return Sanitize(sb.String())
+}
+
+// splitLines normalizes line endings and tabs in indentation
+// of the source and splits it into lines
+func splitLines(source string) []string {
+	source = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(source)
+
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		lines[i] = expandIndent(line)
+	}
+
+	return lines
+}
+
+// expandIndent replaces tabs in the indentation of the line with spaces
+func expandIndent(line string) string {
+	if !strings.HasPrefix(strings.TrimLeft(line, " "), "\t") {
+		return line
+	}
+
+	var sb strings.Builder
+	column := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			sb.WriteByte(' ')
+			column++
+		case '\t':
+			spaces := 4 - column%4
+			sb.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+		default:
+			sb.WriteString(line[i:])
+			return sb.String()
+		}
+	}
+
+	return sb.String()
+}
+
+// renderBlocks renders the lines as block elements. Paragraphs
+// are not wrapped in <p> in tight lists.
+func renderBlocks(sb *strings.Builder, lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case isBlank(line):
+			i++
+		case fencePattern.MatchString(line):
+			i = renderFence(sb, lines, i)
+		case headingPattern.MatchString(line):
+			m := headingPattern.FindStringSubmatch(line)
+			renderHeading(sb, len(m[1]), m[2])
+			i++
+		case breakPattern.MatchString(line):
+			sb.WriteString("<hr>\n")
+			i++
+		case quotePattern.MatchString(line):
+			i = renderQuote(sb, lines, i)
+		case listPattern.MatchString(line):
+			i = renderList(sb, lines, i)
+		case indentation(line) >= 4:
+			i = renderIndentedCode(sb, lines, i)
+		default:
+			i = renderParagraph(sb, lines, i, tight)
+		}
+	}
+}
+
+func renderHeading(sb *strings.Builder, level int, text string) {
+	fmt.Fprintf(sb, "<h%d>", level)
+	renderInline(sb, strings.TrimSpace(text), false)
+	fmt.Fprintf(sb, "</h%d>\n", level)
+}
+
+// renderParagraph renders the paragraph starting at the line and returns
+// the index of the line after it. The paragraph becomes a heading when
+// it is underlined with = or -.
+func renderParagraph(sb *strings.Builder, lines []string, i int, tight bool) int {
+	paragraph := make([]string, 0)
+
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if isBlank(line) {
+			break
+		}
+
+		if len(paragraph) > 0 {
+			if m := setextPattern.FindStringSubmatch(line); m != nil {
+				level := 2
+				if m[1][0] == '=' {
+					level = 1
+				}
+				renderHeading(sb, level, strings.Join(paragraph, "\n"))
+				return i + 1
+			}
+
+			if interruptsParagraph(line) {
+				break
+			}
+		}
+
+		paragraph = append(paragraph, strings.TrimLeft(line, " "))
+	}
+
+	text := strings.TrimRight(strings.Join(paragraph, "\n"), " ")
+
+	if tight {
+		renderInline(sb, text, false)
+		sb.WriteByte('\n')
+		return i
+	}
+
+	sb.WriteString("<p>")
+	renderInline(sb, text, false)
+	sb.WriteString("</p>\n")
+
+	return i
+}
+
+// renderFence renders the fenced code block starting at the line and
+// returns the index of the line after it. The first word of the info
+// string is the language of the code.
+func renderFence(sb *strings.Builder, lines []string, i int) int {
+	m := fencePattern.FindStringSubmatch(lines[i])
+	indent, fence, info := len(m[1]), m[2], m[3]
+
+	code := make([]string, 0)
+	for i++; i < len(lines); i++ {
+		if isClosingFence(lines[i], fence) {
+			i++
+			break
+		}
+		code = append(code, trimIndent(lines[i], indent))
+	}
+
+	sb.WriteString("<pre><code")
+	if words := strings.Fields(html.UnescapeString(info)); len(words) > 0 {
+		sb.WriteString(` class="language-` + html.EscapeString(words[0]) + `"`)
+	}
+	sb.WriteString(">")
+	writeCode(sb, code)
+	sb.WriteString("</code></pre>\n")
+
+	return i
+}
+
+// isClosingFence reports whether the line closes the code block
+// opened with the fence
+func isClosingFence(line, fence string) bool {
+	s := strings.TrimLeft(line, " ")
+	if len(line)-len(s) > 3 {
+		return false
+	}
+
+	n := len(s) - len(strings.TrimLeft(s, fence[:1]))
+	return n >= len(fence) && strings.TrimSpace(s[n:]) == ""
+}
+
+// renderIndentedCode renders the code block indented with four spaces
+// and returns the index of the line after it
+func renderIndentedCode(sb *strings.Builder, lines []string, i int) int {
+	code := make([]string, 0)
+	for ; i < len(lines); i++ {
+		if !isBlank(lines[i]) && indentation(lines[i]) < 4 {
+			break
+		}
+		code = append(code, trimIndent(lines[i], 4))
+	}
+
+	// Blank lines after the code belong to the document
+	for len(code) > 0 && isBlank(code[len(code)-1]) {
+		code = code[:len(code)-1]
+	}
+
+	sb.WriteString("<pre><code>")
+	writeCode(sb, code)
+	sb.WriteString("</code></pre>\n")
+
+	return i
+}
+
+func writeCode(sb *strings.Builder, code []string) {
+	for _, line := range code {
+		sb.WriteString(html.EscapeString(line))
+		sb.WriteByte('\n')
+	}
+}
+
+// renderQuote renders the block quote starting at the line and returns
+// the index of the line after it. Paragraphs of the quote may continue
+// on lines without the > marker.
+func renderQuote(sb *strings.Builder, lines []string, i int) int {
+	quote := make([]string, 0)
+
+	for ; i < len(lines); i++ {
+		line := lines[i]
+
+		if m := quotePattern.FindString(line); m != "" {
+			quote = append(quote, line[len(m):])
+			continue
+		}
+
+		lazy := len(quote) > 0 && !isBlank(quote[len(quote)-1])
+		if isBlank(line) || !lazy || interruptsParagraph(line) {
+			break
+		}
+		quote = append(quote, line)
+	}
+
+	sb.WriteString("<blockquote>\n")
+	renderBlocks(sb, quote, false)
+	sb.WriteString("</blockquote>\n")
+
+	return i
+}
+
+// renderList renders the list starting at the line and returns the index
+// of the line after it. Content of the items is indented to the item text.
+// The list is loose when its items are separated by blank lines.
+func renderList(sb *strings.Builder, lines []string, i int) int {
+	first := listPattern.FindStringSubmatch(lines[i])
+	kind := listKind(first[2])
+
+	items := make([][]string, 0)
+	loose := false
+
+	for i < len(lines) && !breakPattern.MatchString(lines[i]) {
+		m := listPattern.FindStringSubmatch(lines[i])
+		if m == nil || listKind(m[2]) != kind {
+			break
+		}
+
+		// Text which is indented more than four spaces after
+		// the marker is an indented code block of the item
+		indent, content := len(m[0]), lines[i][len(m[0]):]
+		if m[3] == "" || len(m[3]) > 4 {
+			indent = len(m[1]) + len(m[2]) + 1
+			content = strings.TrimPrefix(lines[i][len(m[1])+len(m[2]):], " ")
+		}
+
+		item := []string{content}
+
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+
+			if isBlank(line) {
+				item = append(item, "")
+				continue
+			}
+
+			if indentation(line) >= indent {
+				item = append(item, line[indent:])
+				continue
+			}
+
+			lazy := !isBlank(item[len(item)-1])
+			if !lazy || interruptsParagraph(line) || listPattern.MatchString(line) {
+				break
+			}
+			item = append(item, line)
+		}
+
+		trailing := 0
+		for len(item) > 1 && isBlank(item[len(item)-1]) {
+			item = item[:len(item)-1]
+			trailing++
+		}
+
+		for _, line := range item {
+			if isBlank(line) {
+				loose = true
+			}
+		}
+
+		if trailing > 0 && i < len(lines) {
+			if m := listPattern.FindStringSubmatch(lines[i]); m != nil && listKind(m[2]) == kind {
+				loose = true
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	tag := "ul"
+	if kind != "-" && kind != "*" && kind != "+" {
+		tag = "ol"
+	}
+
+	sb.WriteString("<" + tag)
+	if tag == "ol" {
+		if start, _ := strconv.Atoi(strings.TrimRight(first[2], ".)")); start != 1 {
+			sb.WriteString(` start="` + strconv.Itoa(start) + `"`)
+		}
+	}
+	sb.WriteString(">\n")
+
+	for _, item := range items {
+		var content strings.Builder
+		renderBlocks(&content, item, !loose)
+
+		// Text of tight items is not followed by a line break
+		if loose {
+			sb.WriteString("<li>" + content.String() + "</li>\n")
+		} else {
+			sb.WriteString("<li>" + strings.TrimSuffix(content.String(), "\n") + "</li>\n")
+		}
+	}
+
+	sb.WriteString("</" + tag + ">\n")
+
+	return i
+}
+
+// listKind returns the bullet of bullet list items and
+// the delimiter of ordered list items
+func listKind(marker string) string {
+	return marker[len(marker)-1:]
+}
+
+// interruptsParagraph reports whether the line starts a block which
+// ends the paragraph before it. Ordered lists interrupt paragraphs
+// only when they start with 1, empty list items don't interrupt them.
+func interruptsParagraph(line string) bool {
+	if fencePattern.MatchString(line) || headingPattern.MatchString(line) ||
+		breakPattern.MatchString(line) || quotePattern.MatchString(line) {
+		return true
+	}
+
+	m := listPattern.FindStringSubmatch(line)
+	if m == nil || isBlank(line[len(m[0]):]) {
+		return false
+	}
+
+	ordered := listKind(m[2]) == "." || listKind(m[2]) == ")"
+	return !ordered || strings.TrimRight(m[2], ".)") == "1"
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// indentation returns the number of leading spaces of the line
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// trimIndent removes up to n leading spaces of the line
+func trimIndent(line string, n int) string {
+	if spaces := indentation(line); spaces < n {
+		n = spaces
+	}
+	return line[n:]
+}
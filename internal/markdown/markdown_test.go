@@ -0,0 +1,166 @@
+package markdown
+
+import "testing"
+
+const rel = ` rel="nofollow noopener noreferrer"`
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "paragraphs",
+			source:   "first\nline\n\nsecond",
+			expected: "<p>first\nline</p>\n<p>second</p>\n",
+		},
+		{
+			name:     "emphasis",
+			source:   "*em* **strong** ***both*** ~~del~~ _u_ `code *x*`",
+			expected: "<p><em>em</em> <strong>strong</strong> <em><strong>both</strong></em> <del>del</del> <em>u</em> <code>code *x*</code></p>\n",
+		},
+		{
+			name:     "headings",
+			source:   "# Title #\n\nSub\n---",
+			expected: "<h1>Title</h1>\n<h2>Sub</h2>\n",
+		},
+		{
+			name:     "tight list",
+			source:   "- a\n- b\n  - nested\n- c",
+			expected: "<ul>\n<li>a</li>\n<li>b\n<ul>\n<li>nested</li>\n</ul></li>\n<li>c</li>\n</ul>\n",
+		},
+		{
+			name:     "ordered list",
+			source:   "1. a\n2. b",
+			expected: "<ol>\n<li>a</li>\n<li>b</li>\n</ol>\n",
+		},
+		{
+			name:     "loose list with start",
+			source:   "3. a\n\n4. b",
+			expected: "<ol start=\"3\">\n<li><p>a</p>\n</li>\n<li><p>b</p>\n</li>\n</ol>\n",
+		},
+		{
+			name:     "quotes",
+			source:   "> quote\ncontinued\n\n> - item",
+			expected: "<blockquote>\n<p>quote\ncontinued</p>\n</blockquote>\n<blockquote>\n<ul>\n<li>item</li>\n</ul>\n</blockquote>\n",
+		},
+		{
+			name:     "indented code",
+			source:   "    code <b>\n\npara",
+			expected: "<pre><code>code &lt;b&gt;\n</code></pre>\n<p>para</p>\n",
+		},
+		{
+			name:     "fenced code",
+			source:   "```go\nif a < b {\n}\n```",
+			expected: "<pre><code class=\"language-go\">if a &lt; b {\n}\n</code></pre>\n",
+		},
+		{
+			name:     "fence info breaking out of the class",
+			source:   "```js\" onclick=\"x\nvar a = '<b>';\n```",
+			expected: "<pre><code>var a = &#39;&lt;b&gt;&#39;;\n</code></pre>\n",
+		},
+		{
+			name:     "fence info with a tag",
+			source:   "```<script>\ncode\n```",
+			expected: "<pre><code>code\n</code></pre>\n",
+		},
+		{
+			name:     "raw html",
+			source:   "a <b>bold</b> & <script>alert(1)</script>",
+			expected: "<p>a &lt;b&gt;bold&lt;/b&gt; &amp; &lt;script&gt;alert(1)&lt;/script&gt;</p>\n",
+		},
+		{
+			name:     "raw link",
+			source:   `<a href="javascript:alert(1)">x</a>`,
+			expected: "<p>&lt;a href=&#34;javascript:alert(1)&#34;&gt;x&lt;/a&gt;</p>\n",
+		},
+		{
+			name:     "raw image",
+			source:   "<img src=x onerror=alert(1)>",
+			expected: "<p>&lt;img src=x onerror=alert(1)&gt;</p>\n",
+		},
+		{
+			name:     "link",
+			source:   `[x](http://example.com "title")`,
+			expected: `<p><a href="http://example.com" title="title"` + rel + ">x</a></p>\n",
+		},
+		{
+			name:     "mailto link",
+			source:   "[x](mailto:a@example.com)",
+			expected: `<p><a href="mailto:a@example.com"` + rel + ">x</a></p>\n",
+		},
+		{
+			name:     "autolink",
+			source:   "<http://example.com>",
+			expected: `<p><a href="http://example.com"` + rel + ">http://example.com</a></p>\n",
+		},
+		{
+			name:     "bare url",
+			source:   "see https://example.com/a?b=1&c=2.",
+			expected: `<p>see <a href="https://example.com/a?b=1&amp;c=2"` + rel + ">https://example.com/a?b=1&amp;c=2</a>.</p>\n",
+		},
+		{
+			name:     "javascript url",
+			source:   "[x](javascript:alert(1))",
+			expected: "<p><a" + rel + ">x</a></p>\n",
+		},
+		{
+			name:     "mixed case javascript url",
+			source:   "[x](JaVaScRiPt:alert(1))",
+			expected: "<p><a" + rel + ">x</a></p>\n",
+		},
+		{
+			name:     "entity encoded javascript url",
+			source:   "[x](&#106;avascript:alert(1))",
+			expected: "<p><a" + rel + ">x</a></p>\n",
+		},
+		{
+			name:     "control character split javascript url",
+			source:   "[x](java&#x09;script:alert(1))",
+			expected: "<p><a" + rel + ">x</a></p>\n",
+		},
+		{
+			name:     "data url",
+			source:   "[x](data:text/html;base64,PHNjcmlwdD4=)",
+			expected: "<p><a" + rel + ">x</a></p>\n",
+		},
+		{
+			name:     "javascript image",
+			source:   "![x](javascript:alert(1))",
+			expected: "<p></p>\n",
+		},
+		{
+			name:     "data image",
+			source:   "![x](data:image/png;base64,AAAA)",
+			expected: "<p></p>\n",
+		},
+		{
+			name:     "title breaking out of the attribute",
+			source:   `[x](http://example.com "t\" onmouseover=\"alert(1)")`,
+			expected: `<p><a href="http://example.com" title="t&#34; onmouseover=&#34;alert(1)"` + rel + ">x</a></p>\n",
+		},
+		{
+			name:     "single quoted title breaking out of the attribute",
+			source:   `[x](http://example.com 'a" onclick="b')`,
+			expected: `<p><a href="http://example.com" title="a&#34; onclick=&#34;b"` + rel + ">x</a></p>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := Render(tt.source); result != tt.expected {
+				t.Errorf("Render(%q)\n got %q\nwant %q", tt.source, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRenderPlain(t *testing.T) {
+	source := "a <b>\nline\n\npara & x"
+	expected := "<p>a &lt;b&gt;<br>\nline</p>\n<p>para &amp; x</p>\n"
+
+	if result := RenderPlain(source); result != expected {
+		t.Errorf("RenderPlain(%q)\n got %q\nwant %q", source, result, expected)
+	}
+}
@@ -0,0 +1,292 @@
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// allowedElements are HTML elements which are kept by Sanitize
+// with the attributes which are allowed on them
+var allowedElements = map[string][]string{
+	"p":          nil,
+	"br":         nil,
+	"hr":         nil,
+	"h1":         nil,
+	"h2":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"blockquote": nil,
+	"pre":        nil,
+	"code":       {"class"},
+	"em":         nil,
+	"strong":     nil,
+	"del":        nil,
+	"ul":         nil,
+	"ol":         {"start"},
+	"li":         nil,
+	"a":          {"href", "title"},
+	"img":        {"src", "alt", "title"},
+}
+
+// voidElements are allowed elements which have no content and end tag
+var voidElements = map[string]bool{
+	"br":  true,
+	"hr":  true,
+	"img": true,
+}
+
+// droppedElements are removed together with their content,
+// content of other elements which are not allowed is kept
+var droppedElements = map[string]bool{
+	"script":    true,
+	"style":     true,
+	"iframe":    true,
+	"object":    true,
+	"embed":     true,
+	"noscript":  true,
+	"noembed":   true,
+	"noframes":  true,
+	"template":  true,
+	"textarea":  true,
+	"title":     true,
+	"xmp":       true,
+	"svg":       true,
+	"math":      true,
+	"plaintext": true,
+}
+
+// Schemes of URLs which are allowed in links and images,
+// relative URLs are allowed too
+var (
+	linkSchemes  = map[string]bool{"http": true, "https": true, "mailto": true}
+	imageSchemes = map[string]bool{"http": true, "https": true}
+)
+
+// linkRel is set on every link, so search engines don't follow
+// links of users and linked pages can't reach the opener window
+const linkRel = "nofollow noopener noreferrer"
+
+var (
+	tagPattern       = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9-]*)`)
+	attributePattern = regexp.MustCompile(`^[\s/]*([^\s"'>/=]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+	tagEndPattern    = regexp.MustCompile(`^[\s/]*>`)
+	languagePattern  = regexp.MustCompile(`^language-[a-zA-Z0-9_+#.-]{1,32}$`)
+	listStartPattern = regexp.MustCompile(`^[0-9]{1,9}$`)
+	strippedPattern  = regexp.MustCompile(`<[^>]*>`)
+)
+
+// tag is a start or end tag of an HTML element
+type tag struct {
+	name       string
+	closing    bool
+	attributes [][2]string
+}
+
+// Sanitize removes HTML elements and attributes which are not allowed,
+// links and images with URLs of unsafe schemes, comments and scripts.
+// Text is escaped, elements are balanced, so the result can't break
+// out of the surrounding markup.
+func Sanitize(source string) string {
+	var sb strings.Builder
+	open := make([]string, 0)
+
+	for len(source) > 0 {
+		i := strings.IndexByte(source, '<')
+		if i < 0 {
+			writeText(&sb, source)
+			break
+		}
+
+		writeText(&sb, source[:i])
+		source = source[i:]
+
+		if strings.HasPrefix(source, "<!--") {
+			end := strings.Index(source[4:], "-->")
+			if end < 0 {
+				break
+			}
+			source = source[4+end+3:]
+			continue
+		}
+
+		t, rest, ok := parseTag(source)
+		if !ok {
+			sb.WriteString("&lt;")
+			source = source[1:]
+			continue
+		}
+		source = rest
+
+		switch {
+		case droppedElements[t.name]:
+			if !t.closing {
+				source = skipElement(source, t.name)
+			}
+		case !isAllowedElement(t.name):
+			// The tag is dropped, its content is kept
+		case t.closing:
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j] == t.name {
+					for _, name := range reversed(open[j:]) {
+						sb.WriteString("</" + name + ">")
+					}
+					open = open[:j]
+					break
+				}
+			}
+		default:
+			if writeStartTag(&sb, t) && !voidElements[t.name] {
+				open = append(open, t.name)
+			}
+		}
+	}
+
+	for _, name := range reversed(open) {
+		sb.WriteString("</" + name + ">")
+	}
+
+	return sb.String()
+}
+
+// parseTag parses the tag at the start of the source and returns the rest
+// of the source. Attribute values are unescaped.
+func parseTag(source string) (tag, string, bool) {
+	m := tagPattern.FindStringSubmatch(source)
+	if m == nil {
+		return tag{}, "", false
+	}
+
+	t := tag{name: strings.ToLower(m[2]), closing: m[1] == "/"}
+	source = source[len(m[0]):]
+
+	for {
+		if end := tagEndPattern.FindString(source); end != "" {
+			return t, source[len(end):], true
+		}
+
+		a := attributePattern.FindStringSubmatch(source)
+		if a == nil {
+			return tag{}, "", false
+		}
+		source = source[len(a[0]):]
+
+		value := html.UnescapeString(a[2] + a[3] + a[4])
+		t.attributes = append(t.attributes, [2]string{strings.ToLower(a[1]), value})
+	}
+}
+
+// writeStartTag writes the start tag with the allowed attributes. It returns
+// false when the element is dropped, images are dropped without safe source.
+func writeStartTag(sb *strings.Builder, t tag) bool {
+	var attributes strings.Builder
+	written := make(map[string]bool)
+
+	for _, attribute := range t.attributes {
+		name, value := attribute[0], attribute[1]
+		if written[name] || !isAllowedAttribute(t.name, name) {
+			continue
+		}
+
+		switch {
+		case name == "href":
+			value, written[name] = safeUrl(value, linkSchemes)
+		case name == "src":
+			value, written[name] = safeUrl(value, imageSchemes)
+		case name == "class":
+			written[name] = languagePattern.MatchString(value)
+		case name == "start":
+			written[name] = listStartPattern.MatchString(value)
+		default:
+			written[name] = true
+		}
+
+		if written[name] {
+			attributes.WriteString(" " + name + `="` + html.EscapeString(value) + `"`)
+		}
+	}
+
+	if t.name == "img" && !written["src"] {
+		return false
+	}
+
+	if t.name == "a" {
+		attributes.WriteString(` rel="` + linkRel + `"`)
+	}
+
+	sb.WriteString("<" + t.name + attributes.String() + ">")
+
+	return true
+}
+
+// safeUrl returns the URL without control characters, which are ignored
+// by browsers, and with escaped spaces. It returns false for URLs with
+// schemes which are not allowed.
+func safeUrl(value string, schemes map[string]bool) (string, bool) {
+	value = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(value))
+	value = strings.ReplaceAll(value, " ", "%20")
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", false
+	}
+
+	return value, u.Scheme == "" || schemes[u.Scheme]
+}
+
+// skipElement skips the content of the element up to its end tag
+func skipElement(source, name string) string {
+	for i := strings.Index(source, "</"); i >= 0; i = strings.Index(source, "</") {
+		source = source[i:]
+
+		if len(source) >= len(name)+2 && strings.EqualFold(source[2:len(name)+2], name) {
+			if end := strings.IndexByte(source, '>'); end >= 0 {
+				return source[end+1:]
+			}
+			return ""
+		}
+
+		source = source[2:]
+	}
+
+	return ""
+}
+
+func writeText(sb *strings.Builder, text string) {
+	sb.WriteString(html.EscapeString(html.UnescapeString(text)))
+}
+
+func isAllowedElement(name string) bool {
+	_, ok := allowedElements[name]
+	return ok
+}
+
+func isAllowedAttribute(element, attribute string) bool {
+	for _, allowed := range allowedElements[element] {
+		if allowed == attribute {
+			return true
+		}
+	}
+	return false
+}
+
+func reversed(names []string) []string {
+	result := make([]string, len(names))
+	for i, name := range names {
+		result[len(names)-1-i] = name
+	}
+	return result
+}
+
+// stripTags removes tags of the sanitized HTML
+func stripTags(source string) string {
+	return strippedPattern.ReplaceAllString(source, "")
+}
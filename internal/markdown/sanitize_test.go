@@ -0,0 +1,141 @@
+package markdown
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "allowed elements",
+			source:   "<p><em>a</em> <strong>b</strong></p>",
+			expected: "<p><em>a</em> <strong>b</strong></p>",
+		},
+		{
+			name:     "attributes which are not allowed",
+			source:   `<p onclick="x" style="color: red">a</p>`,
+			expected: "<p>a</p>",
+		},
+		{
+			name:     "elements which are not allowed",
+			source:   "<div>a<span>b</span></div>",
+			expected: "ab",
+		},
+		{
+			name:     "scripts",
+			source:   "a<script>alert(1)</script>b<SCRIPT>alert(2)</script >c",
+			expected: "abc",
+		},
+		{
+			name:     "comments",
+			source:   "a<!-- <script>alert(1)</script> -->b",
+			expected: "ab",
+		},
+		{
+			name:     "unclosed elements",
+			source:   "<ul><li><em>a",
+			expected: "<ul><li><em>a</em></li></ul>",
+		},
+		{
+			name:     "misnested elements",
+			source:   "<p><em>a</p>b",
+			expected: "<p><em>a</em></p>b",
+		},
+		{
+			name:     "text",
+			source:   `a < b & "c"`,
+			expected: "a &lt; b &amp; &#34;c&#34;",
+		},
+		{
+			name:     "link",
+			source:   `<a href="http://example.com" target="_blank" rel="opener">x</a>`,
+			expected: `<a href="http://example.com"` + rel + ">x</a>",
+		},
+		{
+			name:     "relative link",
+			source:   `<a href="/posts/1">x</a>`,
+			expected: `<a href="/posts/1"` + rel + ">x</a>",
+		},
+		{
+			name:     "javascript link",
+			source:   `<a href="javascript:alert(1)">x</a>`,
+			expected: "<a" + rel + ">x</a>",
+		},
+		{
+			name:     "entity encoded javascript link",
+			source:   `<a href="&#x6A;avascript&colon;alert(1)">x</a>`,
+			expected: "<a" + rel + ">x</a>",
+		},
+		{
+			name:     "control character split javascript link",
+			source:   "<a href=\"java\x00scr\nipt:alert(1)\">x</a>",
+			expected: "<a" + rel + ">x</a>",
+		},
+		{
+			name:     "javascript link with leading space",
+			source:   `<a href=" javascript:alert(1)">x</a>`,
+			expected: "<a" + rel + ">x</a>",
+		},
+		{
+			name:     "vbscript link",
+			source:   `<a href="vbscript:msgbox(1)">x</a>`,
+			expected: "<a" + rel + ">x</a>",
+		},
+		{
+			name:     "data link",
+			source:   `<a href="data:text/html,<script>alert(1)</script>">x</a>`,
+			expected: "<a" + rel + ">x</a>",
+		},
+		{
+			name:     "image",
+			source:   `<img src="https://example.com/a.png" alt="a" onerror="alert(1)">`,
+			expected: `<img src="https://example.com/a.png" alt="a">`,
+		},
+		{
+			name:     "data image",
+			source:   `<img src="data:image/svg+xml;base64,AAAA">`,
+			expected: "",
+		},
+		{
+			name:     "image event handler",
+			source:   `<img src=x onerror=alert(1)>`,
+			expected: `<img src="x">`,
+		},
+		{
+			name:     "title breaking out of the attribute",
+			source:   `<a href="/" title='a" onclick="b'>x</a>`,
+			expected: `<a href="/" title="a&#34; onclick=&#34;b"` + rel + ">x</a>",
+		},
+		{
+			name:     "code language",
+			source:   `<code class="language-go">a</code><code class="language-go x">b</code>`,
+			expected: `<code class="language-go">a</code><code>b</code>`,
+		},
+		{
+			name:     "list start",
+			source:   `<ol start="3"><li>a</li></ol><ol start="3x"><li>b</li></ol>`,
+			expected: `<ol start="3"><li>a</li></ol><ol><li>b</li></ol>`,
+		},
+		{
+			name:     "broken tag",
+			source:   `<a href="x>y`,
+			expected: "&lt;a href=&#34;x&gt;y",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Sanitize(tt.source)
+			if result != tt.expected {
+				t.Errorf("Sanitize(%q)\n got %q\nwant %q", tt.source, result, tt.expected)
+			}
+
+			// Sanitized HTML must not change when it is sanitized again
+			if again := Sanitize(result); again != result {
+				t.Errorf("Sanitize is not idempotent: %q became %q", result, again)
+			}
+		})
+	}
+}
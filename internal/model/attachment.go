@@ -0,0 +1,62 @@
+package model
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// MaxFileNameLength is the maximum length of the attachment file name
+const MaxFileNameLength = 255
+
+// Attachment is a file attached to the post. Files are stored once
+// by their SHA-256 hash, attachments with the same content share it.
+type Attachment struct {
+	Id     int `json:"id"`
+	PostId int `json:"post_id"`
+	// UserId is the uploader, it is nil when the account has been deleted
+	UserId      *int   `json:"user_id"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Hash        string `json:"sha256"`
+	// Url is the download URL, images are shown inline,
+	// so the URL can be used in the post content
+	Url       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AttachmentUrl returns the download URL of the attachment
+func AttachmentUrl(attachmentId int) string {
+	return "/api/attachments/" + strconv.Itoa(attachmentId)
+}
+
+// IsImage reports whether the attachment can be shown as an image
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// SanitizeFileName drops directories and control characters
+// from the uploaded file name and limits its length
+func SanitizeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+
+	if runes := []rune(name); len(runes) > MaxFileNameLength {
+		name = string(runes[:MaxFileNameLength])
+	}
+
+	return name
+}
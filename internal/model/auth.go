@@ -1,5 +1,12 @@
 package model
 
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+)
+
 type Auth struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
@@ -10,11 +17,106 @@ type TokenDetails struct {
 	RefreshToken string
 	AccessUuid   string
 	RefreshUuid  string
+	FamilyId     string
 	AtExpires    int64
 	RtExpires    int64
 }
 
 type TokenMetadata struct {
 	AccessUuid string
+	FamilyId   string
 	UserId     int
+	Role       string
+
+	// Personal access token fields, they are empty for session tokens
+	PersonalTokenId int
+	Scopes          []string
+}
+
+// IsPersonalToken reports whether the request has been
+// authenticated with a personal access token
+func (t *TokenMetadata) IsPersonalToken() bool {
+	return t.PersonalTokenId != 0
+}
+
+// HasScope reports whether the token is allowed to reach routes
+// which require the scope. Session tokens have every scope.
+func (t *TokenMetadata) HasScope(scope string) bool {
+	if !t.IsPersonalToken() {
+		return true
+	}
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type Session struct {
+	Id        string    `json:"id"`
+	UserAgent string    `json:"user_agent"`
+	Ip        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`
+	Current   bool      `json:"current"`
+}
+
+type TwoFactorCodeDto struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorSigninDto struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+}
+
+type ForgotPasswordDto struct {
+	Email string `json:"email"`
+}
+
+type MagicLinkDto struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordDto struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (f *ForgotPasswordDto) Validate() error {
+	return validation.ValidateStruct(
+		f,
+		validation.Field(&f.Email, is.Email, validation.Required),
+	)
+}
+
+func (m *MagicLinkDto) Validate() error {
+	return validation.ValidateStruct(
+		m,
+		validation.Field(&m.Email, is.Email, validation.Required),
+	)
+}
+
+func (r *ResetPasswordDto) Validate() error {
+	return validation.ValidateStruct(
+		r,
+		validation.Field(&r.Token, validation.Required),
+		validation.Field(&r.Password, is.Alphanumeric, validation.Required),
+	)
+}
+
+func (t *TwoFactorCodeDto) Validate() error {
+	return validation.ValidateStruct(
+		t,
+		validation.Field(&t.Code, validation.Required, validation.Length(1, 32)),
+	)
+}
+
+func (t *TwoFactorSigninDto) Validate() error {
+	return validation.ValidateStruct(
+		t,
+		validation.Field(&t.ChallengeToken, validation.Required),
+		validation.Field(&t.Code, validation.Required, validation.Length(1, 32)),
+	)
 }
@@ -0,0 +1,32 @@
+package model
+
+import "fmt"
+
+// Avatar thumbnail sizes in pixels
+const (
+	AvatarSmallSize = 64
+	AvatarLargeSize = 256
+)
+
+// AvatarSizes are sizes of every avatar thumbnail
+var AvatarSizes = []int{AvatarSmallSize, AvatarLargeSize}
+
+// Avatar has URLs of the avatar thumbnails. Avatars are addressed by
+// the hash of the uploaded image, so the URLs never change their content.
+type Avatar struct {
+	Small string `json:"small"`
+	Large string `json:"large"`
+}
+
+// NewAvatar returns URLs of the avatar thumbnails with the hash
+func NewAvatar(hash string) *Avatar {
+	return &Avatar{
+		Small: "/api/avatars/" + AvatarFileName(hash, AvatarSmallSize),
+		Large: "/api/avatars/" + AvatarFileName(hash, AvatarLargeSize),
+	}
+}
+
+// AvatarFileName returns the file name of the avatar thumbnail of the size
+func AvatarFileName(hash string, size int) string {
+	return fmt.Sprintf("%s_%d.png", hash, size)
+}
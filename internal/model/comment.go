@@ -0,0 +1,83 @@
+package model
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// MaxCommentLength is the maximum length of the comment content
+const MaxCommentLength = 5000
+
+// Comment is a comment on the post. Deleted comments are kept
+// as tombstones without content and author, so replies keep
+// their context.
+type Comment struct {
+	Id        int        `json:"id"`
+	PostId    int        `json:"post_id"`
+	ParentId  *int       `json:"parent_id"`
+	Author    *User      `json:"author"`
+	Content   string     `json:"content"`
+	Deleted   bool       `json:"deleted"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Replies   []*Comment `json:"replies,omitempty"`
+	// Reactions are counts of the comment reactions by kind
+	Reactions map[string]int `json:"reactions"`
+	// MyReactions are kinds of the reactions left by the
+	// authenticated user, they are empty for anonymous users
+	MyReactions []string `json:"my_reactions,omitempty"`
+}
+
+type CreateCommentDto struct {
+	ParentId *int   `json:"parent_id"`
+	Content  string `json:"content"`
+}
+
+type UpdateCommentDto struct {
+	Content string `json:"content"`
+}
+
+func (c *CreateCommentDto) Validate() error {
+	return validation.ValidateStruct(
+		c,
+		validation.Field(&c.ParentId, validation.Min(1)),
+		validation.Field(&c.Content, validation.Required, validation.Length(1, MaxCommentLength)),
+	)
+}
+
+func (c *UpdateCommentDto) Validate() error {
+	return validation.ValidateStruct(
+		c,
+		validation.Field(&c.Content, validation.Required, validation.Length(1, MaxCommentLength)),
+	)
+}
+
+// NewCommentTree nests replies into their parent comments. Roots are
+// returned in the given order, replies are kept in the order of the
+// slice. Replies which parents are not in the slice are dropped.
+func NewCommentTree(roots []Comment, replies []Comment) []*Comment {
+	tree := make([]*Comment, 0, len(roots))
+	byId := make(map[int]*Comment, len(roots)+len(replies))
+
+	for i := range roots {
+		byId[roots[i].Id] = &roots[i]
+		tree = append(tree, &roots[i])
+	}
+
+	for i := range replies {
+		byId[replies[i].Id] = &replies[i]
+	}
+
+	for i := range replies {
+		reply := &replies[i]
+		if reply.ParentId == nil {
+			continue
+		}
+		if parent, ok := byId[*reply.ParentId]; ok {
+			parent.Replies = append(parent.Replies, reply)
+		}
+	}
+
+	return tree
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// FollowCounts are numbers of the followers of the user
+// and the users the user follows
+type FollowCounts struct {
+	Followers int `json:"followers"`
+	Following int `json:"following"`
+}
+
+// Follow is a follower or a followed user with the time of following
+type Follow struct {
+	User       User      `json:"user"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// TimelineEntry is a post in the home feed of the user
+type TimelineEntry struct {
+	PostId      int
+	PublishedAt time.Time
+}
@@ -0,0 +1,12 @@
+package model
+
+// UserIdentity links the user to the account
+// at an external OpenID Connect provider
+type UserIdentity struct {
+	Id        int    `json:"id"`
+	UserId    int    `json:"user_id"`
+	Provider  string `json:"provider"`
+	Subject   string `json:"subject"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"created_at"`
+}
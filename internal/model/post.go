@@ -1,37 +1,211 @@
 package model
 
 import (
+	"errors"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
 	validation "github.com/go-ozzo/ozzo-validation"
-	"github.com/go-ozzo/ozzo-validation/is"
 )
 
+// Post statuses. Only published posts are listed, drafts and scheduled
+// posts are visible only to the author. Scheduled posts are published
+// at PublishedAt. Archived posts are not listed, but can be read.
+const (
+	PostStatusDraft     = "draft"
+	PostStatusScheduled = "scheduled"
+	PostStatusPublished = "published"
+	PostStatusArchived  = "archived"
+)
+
+// PostStatuses is a list of every post status
+var PostStatuses = []interface{}{PostStatusDraft, PostStatusScheduled, PostStatusPublished, PostStatusArchived}
+
+// Post content formats. Content of both formats is rendered to HTML,
+// plain text keeps paragraphs and line breaks only.
+const (
+	PostFormatPlain    = "plain"
+	PostFormatMarkdown = "markdown"
+)
+
+// PostFormats is a list of every post content format
+var PostFormats = []interface{}{PostFormatPlain, PostFormatMarkdown}
+
+// MaxPostTitleLength and MaxPostContentLength are lengths in characters
+const (
+	MaxPostTitleLength   = 200
+	MaxPostContentLength = 100000
+)
+
+// textRule checks that the text is valid UTF-8 without control characters.
+// Line breaks and tabs are allowed in multiline text only.
+func textRule(multiline bool) validation.Rule {
+	return validation.By(func(value interface{}) error {
+		value, _ = validation.Indirect(value)
+		text, _ := value.(string)
+		if !utf8.ValidString(text) {
+			return errors.New("must be a valid UTF-8 text")
+		}
+
+		for _, r := range text {
+			if multiline && (r == '\n' || r == '\r' || r == '\t') {
+				continue
+			}
+			if unicode.IsControl(r) {
+				return errors.New("must not contain control characters")
+			}
+		}
+
+		return nil
+	})
+}
+
 type Post struct {
-	Id        int    `json:"id"`
-	Title     string `json:"title"`
-	Content   string `json:"content"`
-	Author    User   `json:"author"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
+	Id      int    `json:"id"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	Format  string `json:"format"`
+	// ContentHTML is the sanitized HTML rendered from the content
+	ContentHTML string `json:"content_html"`
+	Author      User   `json:"author"`
+	// Authors are the owner and co-authors of the post
+	Authors     []User     `json:"authors"`
+	Tags        []string   `json:"tags"`
+	Status      string     `json:"status"`
+	PublishedAt *time.Time `json:"published_at"`
+	CreatedAt   string     `json:"created_at"`
+	UpdatedAt   string     `json:"updated_at"`
+	// Reactions are counts of the post reactions by kind
+	Reactions map[string]int `json:"reactions"`
+	// MyReactions are kinds of the reactions left by the
+	// authenticated user, they are empty for anonymous users
+	MyReactions []string `json:"my_reactions,omitempty"`
+	// Version is increased on every update, clients get it as ETag
+	Version int `json:"-"`
+}
+
+// PostSearchResult is a post found by the search query. Snippet is
+// a part of the post content with matches wrapped in <mark> tags,
+// the rest of it is escaped, so it is safe to show as HTML.
+type PostSearchResult struct {
+	Post
+	Snippet string  `json:"snippet"`
+	Rank    float32 `json:"rank"`
 }
 
 type UpdatePostDto struct {
-	Title    *string `json:"title"`
-	Content  *string `json:"content"`
-	AuthorId *int    `json:"author_id"`
+	Title    *string   `json:"title"`
+	Content  *string   `json:"content"`
+	Format   *string   `json:"format"`
+	AuthorId *int      `json:"author_id"`
+	Tags     *[]string `json:"tags"`
+	Status   *string   `json:"status"`
+	// PublishedAt is the time scheduled posts are published at
+	PublishedAt *time.Time `json:"published_at"`
+	// ContentHTML is rendered from the content when
+	// the content or the format are changed
+	ContentHTML *string `json:"-"`
+	// Version is the version the update is based on.
+	// The post is updated regardless of its version if nil.
+	Version *int `json:"-"`
+}
+
+// InviteAuthorDto is an invitation of the user to co-author the post
+type InviteAuthorDto struct {
+	UserId int `json:"user_id"`
 }
 
 func (p *Post) Validate() error {
 	return validation.ValidateStruct(
 		p,
-		validation.Field(&p.Title, is.Alphanumeric, validation.Length(1, 200), validation.Required),
-		validation.Field(&p.Content, is.ASCII, validation.Length(1, 0), validation.Required),
+		validation.Field(&p.Title, validation.Required, validation.RuneLength(1, MaxPostTitleLength), textRule(false)),
+		validation.Field(&p.Content, validation.Required, validation.RuneLength(1, MaxPostContentLength), textRule(true)),
+		validation.Field(&p.Format, validation.In(PostFormats...)),
+		validation.Field(&p.Tags, tagsRules...),
+		validation.Field(&p.Status, validation.In(PostStatusDraft, PostStatusScheduled, PostStatusPublished)),
+		validation.Field(&p.PublishedAt, validation.By(func(value interface{}) error {
+			return validatePublishedAt(p.Status, p.PublishedAt)
+		})),
 	)
 }
 
+// HasAuthor reports whether the user is the owner or a co-author of the post
+func (p *Post) HasAuthor(userId int) bool {
+	if p.Author.Id == userId {
+		return true
+	}
+
+	for _, author := range p.Authors {
+		if author.Id == userId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsVisible reports whether the post can be read by other users than the author
+func (p *Post) IsVisible() bool {
+	return p.Status == PostStatusPublished || p.Status == PostStatusArchived
+}
+
 func (p *UpdatePostDto) Validate() error {
 	return validation.ValidateStruct(
 		p,
-		validation.Field(&p.Title, is.ASCII, validation.Length(1, 200)),
-		validation.Field(&p.Content, is.ASCII, validation.Length(1, 0)),
+		validation.Field(&p.Title, validation.RuneLength(1, MaxPostTitleLength), textRule(false)),
+		validation.Field(&p.Content, validation.RuneLength(1, MaxPostContentLength), textRule(true)),
+		validation.Field(&p.Format, validation.In(PostFormats...)),
+		validation.Field(&p.Tags, validation.By(func(value interface{}) error {
+			// Each rule doesn't accept pointers, so the tags are validated by value
+			if tags, _ := value.(*[]string); tags != nil {
+				return validation.Validate(*tags, tagsRules...)
+			}
+			return nil
+		})),
+		validation.Field(&p.Status, validation.In(PostStatuses...)),
+	)
+}
+
+// ValidateStatus checks the status change of the post. Scheduled posts
+// must get the publishing time in the future, unless it's kept.
+func (p *UpdatePostDto) ValidateStatus(post *Post) error {
+	status := post.Status
+	if p.Status != nil {
+		status = *p.Status
+	}
+
+	if status == PostStatusScheduled && post.Status == PostStatusScheduled && p.PublishedAt == nil {
+		return nil
+	}
+
+	if err := validatePublishedAt(status, p.PublishedAt); err != nil {
+		return validation.Errors{"published_at": err}
+	}
+
+	return nil
+}
+
+// validatePublishedAt checks the publishing time of the post with the status.
+// The time must be set in the future for scheduled posts only.
+func validatePublishedAt(status string, publishedAt *time.Time) error {
+	if status == PostStatusScheduled {
+		if publishedAt == nil {
+			return errors.New("is required for scheduled posts")
+		}
+		return isFutureTime(publishedAt)
+	}
+
+	if publishedAt != nil {
+		return errors.New("can be set only for scheduled posts")
+	}
+
+	return nil
+}
+
+func (i *InviteAuthorDto) Validate() error {
+	return validation.ValidateStruct(
+		i,
+		validation.Field(&i.UserId, validation.Required, validation.Min(1)),
 	)
 }
@@ -0,0 +1,32 @@
+package model
+
+// Reaction kinds. Every user can leave one
+// reaction of each kind on a post or a comment.
+const (
+	ReactionLike  = "like"
+	ReactionLove  = "love"
+	ReactionLaugh = "laugh"
+	ReactionWow   = "wow"
+	ReactionSad   = "sad"
+	ReactionAngry = "angry"
+)
+
+// ReactionKinds is a list of every known reaction kind
+var ReactionKinds = []string{
+	ReactionLike,
+	ReactionLove,
+	ReactionLaugh,
+	ReactionWow,
+	ReactionSad,
+	ReactionAngry,
+}
+
+// IsReactionKind reports whether the kind is a known reaction kind
+func IsReactionKind(kind string) bool {
+	for _, k := range ReactionKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
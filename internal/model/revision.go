@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// PostRevision is a saved state of the post title, content and its format.
+// A revision is written every time the post is created or updated,
+// revisions are numbered from 1 for every post.
+type PostRevision struct {
+	Id       int    `json:"-"`
+	PostId   int    `json:"post_id"`
+	Revision int    `json:"revision"`
+	Title    string `json:"title"`
+	// Content is omitted when revisions are listed
+	Content string `json:"content,omitempty"`
+	Format  string `json:"format"`
+	// Editor is nil when the editor account has been deleted
+	Editor    *User     `json:"editor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PostRevisionDiff is a unified diff between two revisions of the post
+type PostRevisionDiff struct {
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	Diff string `json:"diff"`
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"strings"
+	"unicode"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// Tag limits
+const (
+	MaxPostTags  = 10
+	MaxTagLength = 50
+)
+
+// tagsRules validate post tags before they are normalized
+var tagsRules = []validation.Rule{
+	validation.Length(0, MaxPostTags),
+	validation.Each(validation.Length(1, MaxTagLength)),
+}
+
+type Tag struct {
+	Slug      string `json:"slug"`
+	PostCount int    `json:"post_count"`
+}
+
+// Slugify normalizes the tag name into the slug. Letters are lowercased,
+// every run of other characters than letters and digits becomes a dash.
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+
+	return b.String()
+}
+
+// NormalizeTags turns tag names into unique slugs. Names which
+// have neither letters nor digits are dropped.
+func NormalizeTags(names []string) []string {
+	slugs := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+
+	for _, name := range names {
+		slug := Slugify(name)
+		if slug == "" || seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		slugs = append(slugs, slug)
+	}
+
+	return slugs
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// Personal access token scopes. Tokens are allowed
+// to reach only routes which require granted scopes.
+// Public user routes don't need a token, users:read
+// allows to read data of the token owner, like the feed.
+const (
+	ScopeUsersRead     = "users:read"
+	ScopePostsWrite    = "posts:write"
+	ScopeUsersWrite    = "users:write"
+	ScopeCommentsWrite = "comments:write"
+)
+
+// Scopes is a list of every known scope
+var Scopes = []interface{}{ScopeUsersRead, ScopePostsWrite, ScopeUsersWrite, ScopeCommentsWrite}
+
+type PersonalAccessToken struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"-"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	TokenHash  string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type CreatePersonalAccessTokenDto struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (t *CreatePersonalAccessTokenDto) Validate() error {
+	return validation.ValidateStruct(
+		t,
+		validation.Field(&t.Name, validation.Required, validation.Length(1, 100)),
+		validation.Field(&t.Scopes, validation.Required, validation.Each(validation.In(Scopes...))),
+		validation.Field(&t.ExpiresAt, validation.By(isFutureTime)),
+	)
+}
+
+// IsExpired reports whether the token has expired
+func (t *PersonalAccessToken) IsExpired() bool {
+	return t.ExpiresAt != nil && t.ExpiresAt.Before(time.Now())
+}
+
+func isFutureTime(value interface{}) error {
+	t, ok := value.(*time.Time)
+	if !ok || t == nil {
+		return nil
+	}
+	if !t.After(time.Now()) {
+		return errors.New("must be in the future")
+	}
+	return nil
+}
@@ -1,25 +1,93 @@
 package model
 
 import (
+	"regexp"
+	"time"
+
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Profile limits
+const (
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 500
+	MaxLocationLength    = 100
+	MaxProfileLinks      = 5
+	MaxLinkLength        = 200
+)
+
+// linkRules validate profile links, only web links are allowed
+var linkRules = []validation.Rule{
+	validation.Length(0, MaxProfileLinks),
+	validation.Each(
+		validation.Required,
+		validation.Length(1, MaxLinkLength),
+		validation.Match(regexp.MustCompile(`^https?://`)).Error("must be an http or https link"),
+		is.URL,
+	),
+}
+
+// User roles. Every registered user gets RoleUser,
+// privileged roles are granted by an administrator.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type User struct {
-	Id           int    `json:"id"`
-	Username     string `json:"username"`
-	Email        string `json:"email,omitempty"`
-	RegisteredAt string `json:"registered_at,omitempty"`
-	Password     string `json:"password,omitempty"`
-	IsVerified   bool   `json:"verified"`
+	Id           int      `json:"id"`
+	Username     string   `json:"username"`
+	Email        string   `json:"email,omitempty"`
+	RegisteredAt string   `json:"registered_at,omitempty"`
+	Password     string   `json:"password,omitempty"`
+	IsVerified   bool     `json:"verified"`
+	Role         string   `json:"role,omitempty"`
+	TotpEnabled  bool     `json:"two_factor_enabled"`
+	TotpSecret   []byte   `json:"-"`
+	DisplayName  string   `json:"display_name,omitempty"`
+	Bio          string   `json:"bio,omitempty"`
+	Location     string   `json:"location,omitempty"`
+	Links        []string `json:"links,omitempty"`
+	// Avatar is nil when the user hasn't uploaded one
+	Avatar *Avatar `json:"avatar,omitempty"`
+	// Follows are set only when a single user is requested
+	Follows *FollowCounts `json:"follows,omitempty"`
+	// Version is increased on every update, clients get it as ETag
+	Version int `json:"-"`
 }
 
+// UpdateUserDto contains user fields which can be updated directly.
+// Email is changed with ChangeEmailDto, verification can be changed
+// only by an administrator.
 type UpdateUserDto struct {
-	Username   *string `json:"username"`
-	Email      *string `json:"email"`
-	Password   *string `json:"password"`
-	IsVerified *bool   `json:"verified"`
+	Username    *string   `json:"username"`
+	Password    *string   `json:"password"`
+	IsVerified  *bool     `json:"verified"`
+	Role        *string   `json:"role"`
+	DisplayName *string   `json:"display_name"`
+	Bio         *string   `json:"bio"`
+	Location    *string   `json:"location"`
+	Links       *[]string `json:"links"`
+	// Version is the version the update is based on.
+	// The user is updated regardless of its version if nil.
+	Version *int `json:"-"`
+}
+
+type ChangeEmailDto struct {
+	Email string `json:"email"`
+}
+
+// EmailChange is a pending change of the user email. The email
+// is changed only after the new address has been confirmed.
+type EmailChange struct {
+	UserId          int
+	NewEmail        string
+	TokenHash       string
+	CancelTokenHash string
+	ExpiresAt       time.Time
 }
 
 func (u *User) Validate() error {
@@ -52,7 +120,24 @@ func (u *UpdateUserDto) Validate() error {
 	return validation.ValidateStruct(
 		u,
 		validation.Field(&u.Username, is.Alphanumeric, validation.Length(3, 20)),
-		validation.Field(&u.Email, is.Email),
 		validation.Field(&u.Password, is.Alphanumeric),
+		validation.Field(&u.Role, validation.In(RoleUser, RoleModerator, RoleAdmin)),
+		validation.Field(&u.DisplayName, validation.Length(0, MaxDisplayNameLength)),
+		validation.Field(&u.Bio, validation.Length(0, MaxBioLength)),
+		validation.Field(&u.Location, validation.Length(0, MaxLocationLength)),
+		validation.Field(&u.Links, validation.By(func(value interface{}) error {
+			// Each rule doesn't accept pointers, so the links are validated by value
+			if links, _ := value.(*[]string); links != nil {
+				return validation.Validate(*links, linkRules...)
+			}
+			return nil
+		})),
+	)
+}
+
+func (c *ChangeEmailDto) Validate() error {
+	return validation.ValidateStruct(
+		c,
+		validation.Field(&c.Email, is.Email, validation.Required),
 	)
 }
@@ -0,0 +1,43 @@
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	Issuer       string   `mapstructure:"issuer"`
+	ClientId     string   `mapstructure:"clientId"`
+	ClientSecret string   `mapstructure:"-"`
+	RedirectUrl  string   `mapstructure:"redirectUrl"`
+	Scopes       []string `mapstructure:"scopes"`
+}
+
+// NewConfigs reads configs of every provider from oidc.providers.
+// Client secrets are taken from OIDC_<PROVIDER>_CLIENT_SECRET
+// environment variables.
+func NewConfigs() (map[string]*Config, error) {
+	configs := make(map[string]*Config)
+
+	if err := viper.UnmarshalKey("oidc.providers", &configs); err != nil {
+		return nil, err
+	}
+
+	for name, cfg := range configs {
+		if cfg.Issuer == "" || cfg.ClientId == "" || cfg.RedirectUrl == "" {
+			return nil, fmt.Errorf("oidc provider %s: %w", name, errors.New("issuer, clientId and redirectUrl are required"))
+		}
+
+		cfg.ClientSecret = os.Getenv(fmt.Sprintf("OIDC_%s_CLIENT_SECRET", strings.ToUpper(name)))
+
+		if len(cfg.Scopes) == 0 {
+			cfg.Scopes = []string{"openid", "email", "profile"}
+		}
+	}
+
+	return configs, nil
+}
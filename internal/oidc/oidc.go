@@ -0,0 +1,324 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ErrInvalidIdToken = errors.New("invalid id token")
+	ErrUnknownKey     = errors.New("unknown id token signing key")
+)
+
+// metadata is a part of the provider discovery document
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// Claims are the ID token claims which are used to sign in the user
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// Provider is an OpenID Connect provider client which implements the
+// authorization code flow with PKCE. Provider metadata is discovered
+// lazily on the first use, so the provider doesn't have to be available
+// when the application starts.
+type Provider struct {
+	Name string
+
+	cfg    *Config
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *metadata
+	keys     map[string]interface{}
+}
+
+// NewProvider creates a provider client. The HTTP client is used for every
+// request to the provider, http.DefaultClient is used if it is nil.
+func NewProvider(name string, cfg *Config, client *http.Client) *Provider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Provider{
+		Name:   name,
+		cfg:    cfg,
+		client: client,
+	}
+}
+
+// NewProviders creates a provider client for every config.
+func NewProviders(configs map[string]*Config) map[string]*Provider {
+	providers := make(map[string]*Provider, len(configs))
+	for name, cfg := range configs {
+		providers[name] = NewProvider(name, cfg, nil)
+	}
+	return providers
+}
+
+// AuthCodeURL returns the URL of the provider consent page.
+// State and nonce are echoed back and must be checked by the caller,
+// the verifier is the PKCE code verifier.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.cfg.ClientId)
+	params.Set("redirect_uri", p.cfg.RedirectUrl)
+	params.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", CodeChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(md.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return md.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange exchanges the authorization code for tokens
+// and returns the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectUrl)
+	form.Set("client_id", p.cfg.ClientId)
+	form.Set("code_verifier", verifier)
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var response struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	status, err := p.doJSON(req, &response)
+	if err != nil {
+		return "", err
+	}
+
+	if status != http.StatusOK || response.Error != "" {
+		return "", fmt.Errorf("token exchange failed with status %d: %s %s", status, response.Error, response.ErrorDescription)
+	}
+
+	if response.IdToken == "" {
+		return "", errors.New("token response has no id token")
+	}
+
+	return response.IdToken, nil
+}
+
+// VerifyIDToken verifies the ID token signature with the provider keys,
+// checks the issuer, the audience, the expiration time and the nonce.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIdToken, nonce string) (*Claims, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(rawIdToken, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected id token signing method %s", token.Method.Alg())
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, md, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidIdToken
+	}
+
+	if !claims.VerifyIssuer(md.Issuer, true) {
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidIdToken)
+	}
+
+	if !hasAudience(claims["aud"], p.cfg.ClientId) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidIdToken)
+	}
+
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("%w: token has expired", ErrInvalidIdToken)
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce == "" || tokenNonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIdToken)
+	}
+
+	result := Claims{}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	result.Name, _ = claims["name"].(string)
+	result.PreferredUsername, _ = claims["preferred_username"].(string)
+
+	// Some providers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string:
+		result.EmailVerified = verified == "true"
+	}
+
+	if result.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIdToken)
+	}
+
+	return &result, nil
+}
+
+// discover fetches and caches the provider discovery document.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var md metadata
+
+	status, err := p.doJSON(req, &md)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery failed with status %d", status)
+	}
+
+	// The issuer must be exactly the one we trust (OpenID Connect Discovery 4.3)
+	if strings.TrimSuffix(md.Issuer, "/") != strings.TrimSuffix(p.cfg.Issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery returned unexpected issuer %q", md.Issuer)
+	}
+
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JwksUri == "" {
+		return nil, errors.New("oidc discovery document is incomplete")
+	}
+
+	p.metadata = &md
+
+	return p.metadata, nil
+}
+
+// key returns the provider public key with given id. Keys are fetched
+// again if the key is unknown, because the provider might rotate them.
+func (p *Provider) key(ctx context.Context, md *metadata, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	keys, err := p.fetchKeys(ctx, md.JwksUri)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+
+	// Provider with a single key may omit its id
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+// doJSON sends the request and decodes JSON response body to dest.
+func (p *Provider) doJSON(req *http.Request, dest interface{}) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if err = json.NewDecoder(resp.Body).Decode(dest); err != nil {
+		return resp.StatusCode, fmt.Errorf("could not decode oidc response: %w", err)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// hasAudience reports whether aud claim, which is either
+// a string or an array, contains the client id.
+func hasAudience(aud interface{}, clientId string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientId
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CodeChallenge returns the S256 PKCE code challenge of the verifier.
+func CodeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,395 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	testClientId = "client"
+	testKeyId    = "key-1"
+	testNonce    = "nonce"
+	testCode     = "code"
+	testVerifier = "verifier-0123456789-0123456789-0123456789"
+)
+
+// testServer is a fake provider which serves discovery, JWKS and token
+// endpoints. The token endpoint returns idToken when the code verifier
+// matches the challenge of testVerifier.
+type testServer struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	issuer  string
+	idToken string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{key: key}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(metadata{
+			Issuer:                s.issuer,
+			AuthorizationEndpoint: s.URL + "/authorize",
+			TokenEndpoint:         s.URL + "/token",
+			JwksUri:               s.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := func(b []byte) string {
+			return base64.RawURLEncoding.EncodeToString(b)
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []jsonWebKey{{
+				Kty: "RSA",
+				Kid: testKeyId,
+				Use: "sig",
+				N:   encode(key.N.Bytes()),
+				E:   encode(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if r.PostForm.Get("code") != testCode ||
+			CodeChallenge(r.PostForm.Get("code_verifier")) != CodeChallenge(testVerifier) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": s.idToken})
+	})
+
+	s.Server = httptest.NewServer(mux)
+	s.issuer = s.URL
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *testServer) provider() *Provider {
+	return NewProvider("test", &Config{
+		Issuer:      s.URL,
+		ClientId:    testClientId,
+		RedirectUrl: "http://localhost/callback",
+		Scopes:      []string{"openid", "email"},
+	}, s.Client())
+}
+
+// validClaims returns claims of the ID token which passes verification
+func (s *testServer) validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            s.issuer,
+		"sub":            "subject",
+		"aud":            testClientId,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          testNonce,
+		"email":          "user@example.com",
+		"email_verified": true,
+	}
+}
+
+func (s *testServer) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKeyId
+
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	s := newTestServer(t)
+
+	hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, s.validClaims()).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noneToken, err := jwt.NewWithClaims(jwt.SigningMethodNone, s.validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+		nonce string
+		// err is a part of the expected error, empty for valid tokens
+		err string
+	}{
+		{
+			name:  "valid",
+			token: func() string { return s.sign(t, s.validClaims()) },
+			nonce: testNonce,
+		},
+		{
+			name: "audience array",
+			token: func() string {
+				claims := s.validClaims()
+				claims["aud"] = []string{"other", testClientId}
+				return s.sign(t, claims)
+			},
+			nonce: testNonce,
+		},
+		{
+			name: "issuer mismatch",
+			token: func() string {
+				claims := s.validClaims()
+				claims["iss"] = "https://attacker.example.com"
+				return s.sign(t, claims)
+			},
+			nonce: testNonce,
+			err:   "unexpected issuer",
+		},
+		{
+			name:  "bad nonce",
+			token: func() string { return s.sign(t, s.validClaims()) },
+			nonce: "other",
+			err:   "nonce mismatch",
+		},
+		{
+			name: "missing nonce",
+			token: func() string {
+				claims := s.validClaims()
+				delete(claims, "nonce")
+				return s.sign(t, claims)
+			},
+			nonce: "",
+			err:   "nonce mismatch",
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				claims := s.validClaims()
+				claims["aud"] = "other"
+				return s.sign(t, claims)
+			},
+			nonce: testNonce,
+			err:   "unexpected audience",
+		},
+		{
+			name: "expired",
+			token: func() string {
+				claims := s.validClaims()
+				claims["exp"] = time.Now().Add(-time.Minute).Unix()
+				return s.sign(t, claims)
+			},
+			nonce: testNonce,
+			err:   "expired",
+		},
+		{
+			name: "no expiration",
+			token: func() string {
+				claims := s.validClaims()
+				delete(claims, "exp")
+				return s.sign(t, claims)
+			},
+			nonce: testNonce,
+			err:   "token has expired",
+		},
+		{
+			name: "no subject",
+			token: func() string {
+				claims := s.validClaims()
+				delete(claims, "sub")
+				return s.sign(t, claims)
+			},
+			nonce: testNonce,
+			err:   "no subject",
+		},
+		{
+			name:  "hmac algorithm",
+			token: func() string { return hmacToken },
+			nonce: testNonce,
+			err:   "unexpected id token signing method",
+		},
+		{
+			name:  "none algorithm",
+			token: func() string { return noneToken },
+			nonce: testNonce,
+			err:   "unexpected id token signing method",
+		},
+		{
+			name: "unknown key",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodRS256, s.validClaims())
+				token.Header["kid"] = "key-2"
+				signed, err := token.SignedString(s.key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signed
+			},
+			nonce: testNonce,
+			err:   "unknown id token signing key",
+		},
+		{
+			name: "foreign key",
+			token: func() string {
+				other, err := rsa.GenerateKey(rand.Reader, 2048)
+				if err != nil {
+					t.Fatal(err)
+				}
+				token := jwt.NewWithClaims(jwt.SigningMethodRS256, s.validClaims())
+				token.Header["kid"] = testKeyId
+				signed, err := token.SignedString(other)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signed
+			},
+			nonce: testNonce,
+			err:   "verification error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := s.provider().VerifyIDToken(context.Background(), tt.token(), tt.nonce)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected %q error, got %v", tt.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if claims.Subject != "subject" || claims.Email != "user@example.com" || !claims.EmailVerified {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenEmailVerifiedString(t *testing.T) {
+	s := newTestServer(t)
+
+	claims := s.validClaims()
+	claims["email_verified"] = "false"
+
+	result, err := s.provider().VerifyIDToken(context.Background(), s.sign(t, claims), testNonce)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.EmailVerified {
+		t.Error("expected the email not to be verified")
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	s := newTestServer(t)
+	s.issuer = "https://attacker.example.com"
+
+	_, err := s.provider().AuthCodeURL(context.Background(), "state", testNonce, testVerifier)
+	if err == nil || !strings.Contains(err.Error(), "unexpected issuer") {
+		t.Fatalf("expected issuer mismatch error, got %v", err)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	s := newTestServer(t)
+
+	raw, err := s.provider().AuthCodeURL(context.Background(), "state", testNonce, testVerifier)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := u.Query()
+	expected := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientId,
+		"state":                 "state",
+		"nonce":                 testNonce,
+		"scope":                 "openid email",
+		"code_challenge":        CodeChallenge(testVerifier),
+		"code_challenge_method": "S256",
+	}
+
+	for name, value := range expected {
+		if query.Get(name) != value {
+			t.Errorf("%s = %q, expected %q", name, query.Get(name), value)
+		}
+	}
+
+	if query.Get("code_verifier") != "" {
+		t.Error("the code verifier must not be sent to the authorization endpoint")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	s := newTestServer(t)
+	s.idToken = s.sign(t, s.validClaims())
+
+	idToken, err := s.provider().Exchange(context.Background(), testCode, testVerifier)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if idToken != s.idToken {
+		t.Errorf("unexpected id token %q", idToken)
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	s := newTestServer(t)
+	s.idToken = s.sign(t, s.validClaims())
+
+	_, err := s.provider().Exchange(context.Background(), testCode, "other")
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("expected invalid_grant error, got %v", err)
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// Example of RFC 7636 Appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	expected := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if challenge := CodeChallenge(verifier); challenge != expected {
+		t.Errorf("CodeChallenge() = %q, expected %q", challenge, expected)
+	}
+}
@@ -8,6 +8,9 @@ type Config struct {
 	Host     string
 	Port     string
 	Name     string
+	// Events is a name of the queue for domain events,
+	// Name queue is used for emails
+	Events string
 }
 
 func NewConfig() *Config {
@@ -17,5 +20,6 @@ func NewConfig() *Config {
 		Host:     viper.GetString("queue.host"),
 		Port:     viper.GetString("queue.port"),
 		Name:     viper.GetString("queue.name"),
+		Events:   viper.GetString("queue.events"),
 	}
 }
@@ -0,0 +1,24 @@
+package queue
+
+import "time"
+
+// Event types
+const (
+	EventPostPublished = "post.published"
+)
+
+// PostPublished is the data of EventPostPublished event
+type PostPublished struct {
+	PostId      int       `json:"post_id"`
+	AuthorId    int       `json:"author_id"`
+	Title       string    `json:"title"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// Event is a domain event which is sent to the events queue,
+// so other services can react to changes
+type Event struct {
+	Type       string      `json:"type"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
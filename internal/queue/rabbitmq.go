@@ -1,7 +1,9 @@
 package queue
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/streadway/amqp"
 	"go.uber.org/zap"
@@ -35,16 +37,18 @@ func NewQueue(logger *zap.SugaredLogger, cfg *Config) (*Queue, error) {
 	}
 	q.ch = ch
 
-	_, err = q.ch.QueueDeclare(
-		cfg.Name,
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		return nil, err
+	for _, name := range []string{cfg.Name, cfg.Events} {
+		_, err = q.ch.QueueDeclare(
+			name,
+			false,
+			false,
+			false,
+			false,
+			nil,
+		)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &q, nil
@@ -63,6 +67,29 @@ func (q *Queue) Dispatch(msg []byte) error {
 	)
 }
 
+// DispatchEvent sends the event with given type and data to the events queue
+func (q *Queue) DispatchEvent(eventType string, data interface{}) error {
+	body, err := json.Marshal(Event{
+		Type:       eventType,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	})
+	if err != nil {
+		return err
+	}
+
+	return q.ch.Publish(
+		"",
+		q.cfg.Events,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		},
+	)
+}
+
 func (q *Queue) Close() error {
 	err := q.ch.Close()
 	if err != nil {
@@ -0,0 +1,18 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	// Interval is how often scheduled posts are checked
+	Interval time.Duration
+}
+
+func NewConfig() *Config {
+	return &Config{
+		Interval: time.Duration(viper.GetInt("scheduler.interval")) * time.Second,
+	}
+}
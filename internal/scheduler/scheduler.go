@@ -0,0 +1,80 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/juicyluv/astral/internal/feed"
+	"github.com/juicyluv/astral/internal/queue"
+	"github.com/juicyluv/astral/internal/store"
+	"go.uber.org/zap"
+)
+
+// Scheduler publishes scheduled posts when their publishing time comes.
+// It is safe to run the scheduler on several instances at once, posts
+// are published by one of them only.
+type Scheduler struct {
+	store  store.Store
+	queue  *queue.Queue
+	feed   *feed.Timelines
+	logger *zap.SugaredLogger
+	cfg    *Config
+}
+
+func NewScheduler(store store.Store, queue *queue.Queue, timelines *feed.Timelines, logger *zap.SugaredLogger, cfg *Config) *Scheduler {
+	return &Scheduler{
+		store:  store,
+		queue:  queue,
+		feed:   timelines,
+		logger: logger,
+		cfg:    cfg,
+	}
+}
+
+// Run checks scheduled posts every interval until the context is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.publishScheduled(ctx)
+		}
+	}
+}
+
+// publishScheduled publishes due posts, emits an event for every one
+// of them and adds them to the timelines of the followers
+func (s *Scheduler) publishScheduled(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Interval)
+	defer cancel()
+
+	posts, err := s.store.Post().PublishScheduled(ctx)
+	if err != nil {
+		s.logger.Errorf("could not publish scheduled posts: %v", err)
+		return
+	}
+
+	for _, post := range posts {
+		err := s.queue.DispatchEvent(queue.EventPostPublished, queue.PostPublished{
+			PostId:      post.Id,
+			AuthorId:    post.Author.Id,
+			Title:       post.Title,
+			PublishedAt: *post.PublishedAt,
+		})
+		if err != nil {
+			s.logger.Errorf("could not dispatch post published event: %v", err)
+		}
+
+		if err = s.feed.FanOut(ctx, post.Id, *post.PublishedAt); err != nil {
+			s.logger.Errorf("could not add post %d to timelines: %v", post.Id, err)
+		}
+	}
+
+	if len(posts) > 0 {
+		s.logger.Infof("%d scheduled posts have been published", len(posts))
+	}
+}
@@ -0,0 +1,63 @@
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// KeySize is the size of the key in bytes, AES-256 is used
+const KeySize = 32
+
+var (
+	ErrInvalidKey          = errors.New("encryption key must be 32 bytes encoded in base64")
+	ErrMalformedCiphertext = errors.New("malformed ciphertext")
+)
+
+// Box encrypts small secrets to store them at rest.
+// It uses AES-GCM, the random nonce is prepended to the ciphertext.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox creates a box with the base64 encoded key.
+func NewBox(encodedKey string) (*Box, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts the plaintext.
+func (b *Box) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts the ciphertext produced by Seal.
+func (b *Box) Open(ciphertext []byte) ([]byte, error) {
+	size := b.aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, ErrMalformedCiphertext
+	}
+
+	return b.aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+}
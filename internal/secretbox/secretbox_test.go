@@ -0,0 +1,101 @@
+package secretbox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+func newTestBox(t *testing.T) *Box {
+	t.Helper()
+
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+
+	box, err := NewBox(base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return box
+}
+
+func TestSealOpen(t *testing.T) {
+	box := newTestBox(t)
+	plaintext := []byte("JBSWY3DPEHPK3PXP")
+
+	sealed, err := box.Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sealed, plaintext) {
+		t.Fatal("sealed data contains the plaintext")
+	}
+
+	opened, err := box.Open(sealed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Open() = %q, expected %q", opened, plaintext)
+	}
+
+	// Every seal uses a new nonce
+	again, err := box.Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sealed, again) {
+		t.Error("sealing the same plaintext twice returned the same ciphertext")
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	box := newTestBox(t)
+
+	sealed, err := box.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range sealed {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 0x01
+
+		if _, err := box.Open(tampered); err == nil {
+			t.Fatalf("Open() accepted the ciphertext with byte %d changed", i)
+		}
+	}
+
+	if _, err := box.Open(sealed[:len(sealed)-1]); err == nil {
+		t.Error("Open() accepted the truncated ciphertext")
+	}
+
+	if _, err := box.Open(sealed[:3]); err != ErrMalformedCiphertext {
+		t.Errorf("Open() of the short ciphertext returned %v, expected ErrMalformedCiphertext", err)
+	}
+}
+
+func TestOpenWrongKey(t *testing.T) {
+	sealed, err := newTestBox(t).Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newTestBox(t).Open(sealed); err == nil {
+		t.Error("Open() accepted the ciphertext sealed with another key")
+	}
+}
+
+func TestNewBoxInvalidKey(t *testing.T) {
+	for _, key := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(make([]byte, 16))} {
+		if _, err := NewBox(key); err != ErrInvalidKey {
+			t.Errorf("NewBox(%q) returned %v, expected ErrInvalidKey", key, err)
+		}
+	}
+}
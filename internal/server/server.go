@@ -5,8 +5,13 @@ import (
 	"net/http"
 
 	"github.com/go-redis/redis/v7"
+	"github.com/juicyluv/astral/internal/blob"
+	"github.com/juicyluv/astral/internal/feed"
 	"github.com/juicyluv/astral/internal/handler"
+	"github.com/juicyluv/astral/internal/keys"
+	"github.com/juicyluv/astral/internal/oidc"
 	"github.com/juicyluv/astral/internal/queue"
+	"github.com/juicyluv/astral/internal/secretbox"
 	"github.com/juicyluv/astral/internal/store"
 	"go.uber.org/zap"
 )
@@ -18,7 +23,7 @@ type Server struct {
 	db     store.Store
 }
 
-func NewServer(cfg *Config, logger *zap.SugaredLogger, store store.Store, redis *redis.Client, queue *queue.Queue) *Server {
+func NewServer(cfg *Config, logger *zap.SugaredLogger, store store.Store, redis *redis.Client, queue *queue.Queue, keys *keys.KeyManager, box *secretbox.Box, timelines *feed.Timelines, blobs blob.Store, providers map[string]*oidc.Provider) *Server {
 	return &Server{
 		cfg:    cfg,
 		logger: logger,
@@ -28,7 +33,7 @@ func NewServer(cfg *Config, logger *zap.SugaredLogger, store store.Store, redis
 			WriteTimeout:   cfg.WriteTimeout,
 			ReadTimeout:    cfg.ReadTimeout,
 			MaxHeaderBytes: cfg.MaxHeaderBytes,
-			Handler:        handler.NewHandler(logger, store, redis, queue).GetRouter(),
+			Handler:        handler.NewHandler(logger, store, redis, queue, keys, box, timelines, blobs, providers).GetRouter(),
 		},
 	}
 }
@@ -0,0 +1,230 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/juicyluv/astral/internal/model"
+	"github.com/juicyluv/astral/internal/store"
+	"go.uber.org/zap"
+)
+
+// attachmentColumns are selected by every attachment query
+const attachmentColumns = `
+	attachment_id, post_id, user_id, file_name,
+	content_type, size, hash, created_at`
+
+type AttachmentRepository struct {
+	db     *pgxpool.Pool
+	logger *zap.SugaredLogger
+}
+
+func NewAttachmentRepository(db *pgxpool.Pool, logger *zap.SugaredLogger) *AttachmentRepository {
+	return &AttachmentRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *AttachmentRepository) TouchBlob(ctx context.Context, hash string, size int64, contentType string) error {
+	// Blobs which have been used recently are not swept,
+	// so the content of the upload in progress is kept
+	query := `
+	INSERT INTO blobs(hash, size, content_type)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (hash) DO UPDATE
+	SET last_used_at = now()`
+
+	_, err := r.db.Exec(ctx, query, hash, size, contentType)
+	return err
+}
+
+func (r *AttachmentRepository) Create(ctx context.Context, attachment *model.Attachment, quota int64) (int, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// The user row is locked, so concurrent uploads can't exceed the quota
+	var used int64
+	query := `
+	SELECT COALESCE((SELECT sum(size) FROM attachments WHERE user_id = u.user_id), 0)
+	FROM users u
+	WHERE u.user_id = $1
+	FOR UPDATE`
+
+	if err = tx.QueryRow(ctx, query, *attachment.UserId).Scan(&used); err != nil {
+		return 0, err
+	}
+
+	if used+attachment.Size > quota {
+		return 0, store.ErrQuotaExceeded
+	}
+
+	query = `
+	INSERT INTO attachments(post_id, user_id, file_name, content_type, size, hash)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING attachment_id, created_at`
+
+	err = tx.QueryRow(
+		ctx,
+		query,
+		attachment.PostId,
+		attachment.UserId,
+		attachment.FileName,
+		attachment.ContentType,
+		attachment.Size,
+		attachment.Hash,
+	).Scan(&attachment.Id, &attachment.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	attachment.Url = model.AttachmentUrl(attachment.Id)
+
+	return attachment.Id, nil
+}
+
+func (r *AttachmentRepository) FindById(ctx context.Context, attachmentId int) (*model.Attachment, error) {
+	query := `
+	SELECT ` + attachmentColumns + `
+	FROM attachments
+	WHERE attachment_id = $1`
+
+	return scanAttachment(r.db.QueryRow(ctx, query, attachmentId))
+}
+
+func (r *AttachmentRepository) FindPostAttachments(ctx context.Context, postId int) ([]model.Attachment, error) {
+	query := `
+	SELECT ` + attachmentColumns + `
+	FROM attachments
+	WHERE post_id = $1
+	ORDER BY created_at, attachment_id`
+
+	rows, err := r.db.Query(ctx, query, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]model.Attachment, 0)
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, *attachment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
+
+func (r *AttachmentRepository) Delete(ctx context.Context, attachmentId int) error {
+	query := `
+	DELETE FROM attachments
+	WHERE attachment_id = $1`
+
+	result, err := r.db.Exec(ctx, query, attachmentId)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *AttachmentRepository) SweepBlobs(ctx context.Context, unusedFor time.Duration, limit int, remove func(hash string) error) (int, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Locked blobs can't be touched by uploads until they are removed,
+	// blobs which are locked by another sweeper are skipped
+	query := `
+	SELECT b.hash
+	FROM blobs b
+	WHERE b.last_used_at < $1 AND NOT EXISTS(
+		SELECT 1 FROM attachments a WHERE a.hash = b.hash
+	)
+	LIMIT $2
+	FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.Query(ctx, query, time.Now().Add(-unusedFor), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	hashes := make([]string, 0)
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Content is removed before the rows, so blobs never lose the content
+	removed := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		if err := remove(hash); err != nil {
+			r.logger.Errorf("could not remove blob %s: %v", hash, err)
+			continue
+		}
+		removed = append(removed, hash)
+	}
+
+	if _, err = tx.Exec(ctx, "DELETE FROM blobs WHERE hash = ANY($1)", removed); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	return len(removed), nil
+}
+
+func scanAttachment(row pgx.Row) (*model.Attachment, error) {
+	var attachment model.Attachment
+
+	err := row.Scan(
+		&attachment.Id,
+		&attachment.PostId,
+		&attachment.UserId,
+		&attachment.FileName,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.Hash,
+		&attachment.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment.Url = model.AttachmentUrl(attachment.Id)
+
+	return &attachment, nil
+}
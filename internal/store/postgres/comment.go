@@ -0,0 +1,229 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/juicyluv/astral/internal/handler/filter"
+	"github.com/juicyluv/astral/internal/model"
+	"go.uber.org/zap"
+)
+
+// commentColumns are selected by every comment query,
+// comments must be aliased as c and users as u
+const commentColumns = `
+	c.comment_id, c.post_id, c.parent_id, c.author_id, u.username,
+	c.content, c.deleted_at IS NOT NULL, c.created_at, c.updated_at,
+	c.reaction_counts`
+
+type CommentRepository struct {
+	db     *pgxpool.Pool
+	logger *zap.SugaredLogger
+}
+
+func NewCommentRepository(db *pgxpool.Pool, logger *zap.SugaredLogger) *CommentRepository {
+	return &CommentRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *CommentRepository) Create(ctx context.Context, comment *model.Comment) (int, error) {
+	query := `
+	INSERT INTO comments(post_id, parent_id, author_id, content)
+	VALUES($1, $2, $3, $4)
+	RETURNING comment_id, created_at, updated_at`
+
+	err := r.db.QueryRow(
+		ctx,
+		query,
+		comment.PostId,
+		comment.ParentId,
+		comment.Author.Id,
+		comment.Content,
+	).Scan(&comment.Id, &comment.CreatedAt, &comment.UpdatedAt)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return comment.Id, nil
+}
+
+func (r *CommentRepository) FindById(ctx context.Context, commentId int) (*model.Comment, error) {
+	query := `
+	SELECT ` + commentColumns + `
+	FROM comments c
+	LEFT JOIN users u
+	ON u.user_id = c.author_id
+	WHERE c.comment_id = $1`
+
+	comment, err := scanComment(r.db.QueryRow(ctx, query, commentId))
+	if err != nil {
+		return nil, err
+	}
+
+	return comment, nil
+}
+
+func (r *CommentRepository) FindPostComments(ctx context.Context, postId int, f *filter.CommentFilter) ([]model.Comment, *filter.Cursor, error) {
+	args := []interface{}{postId}
+	conditions := "c.post_id = $1"
+
+	// The tree view is paginated by top level comments
+	if f.View == filter.CommentViewTree {
+		conditions += " AND c.parent_id IS NULL"
+	}
+
+	if f.After != nil {
+		conditions += " AND (c.created_at, c.comment_id) > ($2, $3)"
+		args = append(args, f.After.Time, f.After.Id)
+	}
+
+	// One more comment is fetched to find out whether there is the next page
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM comments c
+	LEFT JOIN users u
+	ON u.user_id = c.author_id
+	WHERE %s
+	ORDER BY c.created_at, c.comment_id
+	LIMIT $%d`, commentColumns, conditions, len(args)+1)
+	args = append(args, f.Limit+1)
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	comments := make([]model.Comment, 0, f.Limit)
+	var next *filter.Cursor
+
+	for rows.Next() {
+		if len(comments) == f.Limit {
+			last := comments[len(comments)-1]
+			next = &filter.Cursor{Time: last.CreatedAt, Id: last.Id}
+			break
+		}
+
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		comments = append(comments, *comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return comments, next, nil
+}
+
+func (r *CommentRepository) FindReplies(ctx context.Context, commentIds []int) ([]model.Comment, error) {
+	query := `
+	WITH RECURSIVE replies AS (
+		SELECT * FROM comments
+		WHERE parent_id = ANY($1)
+		UNION ALL
+		SELECT child.* FROM comments child
+		INNER JOIN replies parent
+		ON child.parent_id = parent.comment_id
+	)
+	SELECT ` + commentColumns + `
+	FROM replies c
+	LEFT JOIN users u
+	ON u.user_id = c.author_id
+	ORDER BY c.created_at, c.comment_id`
+
+	rows, err := r.db.Query(ctx, query, commentIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	replies := make([]model.Comment, 0)
+
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, *comment)
+	}
+
+	return replies, rows.Err()
+}
+
+func (r *CommentRepository) Update(ctx context.Context, commentId int, content string) error {
+	query := `
+	UPDATE comments
+	SET content = $1, updated_at = now()
+	WHERE comment_id = $2 AND deleted_at IS NULL`
+
+	tag, err := r.db.Exec(ctx, query, content, commentId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *CommentRepository) Delete(ctx context.Context, commentId int) error {
+	// The comment is kept as a tombstone, so replies keep their place
+	query := `
+	UPDATE comments
+	SET content = '', deleted_at = now()
+	WHERE comment_id = $1 AND deleted_at IS NULL`
+
+	tag, err := r.db.Exec(ctx, query, commentId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// scanComment scans the row of commentColumns. Author
+// of the deleted comment is not exposed.
+func scanComment(row pgx.Row) (*model.Comment, error) {
+	var comment model.Comment
+	var authorId *int
+	var username *string
+
+	err := row.Scan(
+		&comment.Id,
+		&comment.PostId,
+		&comment.ParentId,
+		&authorId,
+		&username,
+		&comment.Content,
+		&comment.Deleted,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&comment.Reactions,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if authorId != nil && !comment.Deleted {
+		comment.Author = &model.User{Id: *authorId}
+		if username != nil {
+			comment.Author.Username = *username
+		}
+	}
+
+	return &comment, nil
+}
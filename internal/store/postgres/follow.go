@@ -0,0 +1,201 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/juicyluv/astral/internal/handler/filter"
+	"github.com/juicyluv/astral/internal/model"
+	"go.uber.org/zap"
+)
+
+type FollowRepository struct {
+	db     *pgxpool.Pool
+	logger *zap.SugaredLogger
+}
+
+func NewFollowRepository(db *pgxpool.Pool, logger *zap.SugaredLogger) *FollowRepository {
+	return &FollowRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *FollowRepository) Follow(ctx context.Context, followerId, followeeId int) error {
+	query := `
+	INSERT INTO follows(follower_id, followee_id)
+	VALUES ($1, $2)
+	ON CONFLICT (follower_id, followee_id) DO NOTHING`
+
+	_, err := r.db.Exec(ctx, query, followerId, followeeId)
+	return err
+}
+
+func (r *FollowRepository) Unfollow(ctx context.Context, followerId, followeeId int) error {
+	query := `
+	DELETE FROM follows
+	WHERE follower_id = $1 AND followee_id = $2`
+
+	result, err := r.db.Exec(ctx, query, followerId, followeeId)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *FollowRepository) Counts(ctx context.Context, userId int) (*model.FollowCounts, error) {
+	var counts model.FollowCounts
+
+	query := `
+	SELECT
+	(SELECT count(*) FROM follows WHERE followee_id = $1),
+	(SELECT count(*) FROM follows WHERE follower_id = $1)`
+
+	err := r.db.QueryRow(ctx, query, userId).Scan(&counts.Followers, &counts.Following)
+	if err != nil {
+		return nil, err
+	}
+
+	return &counts, nil
+}
+
+func (r *FollowRepository) FindFollowers(ctx context.Context, userId int, f *filter.PageFilter) ([]model.Follow, *filter.Cursor, error) {
+	return r.findFollows(ctx, "followee_id", "follower_id", userId, f)
+}
+
+func (r *FollowRepository) FindFollowing(ctx context.Context, userId int, f *filter.PageFilter) ([]model.Follow, *filter.Cursor, error) {
+	return r.findFollows(ctx, "follower_id", "followee_id", userId, f)
+}
+
+// findFollows returns a page of users on the other side of the follows of
+// the user, the latest first. Column names are constants of the callers.
+func (r *FollowRepository) findFollows(ctx context.Context, userColumn, otherColumn string, userId int, f *filter.PageFilter) ([]model.Follow, *filter.Cursor, error) {
+	args := []interface{}{userId}
+	conditions := fmt.Sprintf("f.%s = $1", userColumn)
+
+	if f.After != nil {
+		conditions += fmt.Sprintf(" AND (f.created_at, f.%s) < ($2, $3)", otherColumn)
+		args = append(args, f.After.Time, f.After.Id)
+	}
+
+	// One more user is fetched to find out whether there is the next page
+	query := fmt.Sprintf(`
+	SELECT u.user_id, u.username, f.created_at
+	FROM follows f
+	INNER JOIN users u
+	ON u.user_id = f.%[1]s
+	WHERE %[2]s
+	ORDER BY f.created_at DESC, f.%[1]s DESC
+	LIMIT $%[3]d`, otherColumn, conditions, len(args)+1)
+	args = append(args, f.Limit+1)
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	follows := make([]model.Follow, 0, f.Limit)
+	var next *filter.Cursor
+
+	for rows.Next() {
+		var follow model.Follow
+		err := rows.Scan(
+			&follow.User.Id,
+			&follow.User.Username,
+			&follow.FollowedAt,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(follows) == f.Limit {
+			last := follows[len(follows)-1]
+			next = &filter.Cursor{Time: last.FollowedAt, Id: last.User.Id}
+			break
+		}
+
+		follows = append(follows, follow)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return follows, next, nil
+}
+
+func (r *FollowRepository) FindPostFollowers(ctx context.Context, postId int) ([]int, error) {
+	query := `
+	SELECT DISTINCT f.follower_id
+	FROM follows f
+	INNER JOIN user_post up
+	ON up.user_id = f.followee_id
+	WHERE up.post_id = $1 AND up.accepted_at IS NOT NULL`
+
+	rows, err := r.db.Query(ctx, query, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	followers := make([]int, 0)
+
+	for rows.Next() {
+		var followerId int
+		if err := rows.Scan(&followerId); err != nil {
+			return nil, err
+		}
+		followers = append(followers, followerId)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return followers, nil
+}
+
+func (r *FollowRepository) FindTimeline(ctx context.Context, userId, limit int) ([]model.TimelineEntry, error) {
+	query := `
+	SELECT p.post_id, p.published_at
+	FROM posts p
+	WHERE p.status = 'published' AND EXISTS(
+		SELECT 1 FROM user_post up
+		INNER JOIN follows f ON f.followee_id = up.user_id
+		WHERE up.post_id = p.post_id AND up.accepted_at IS NOT NULL AND f.follower_id = $1
+	)
+	ORDER BY p.published_at DESC, p.post_id DESC
+	LIMIT $2`
+
+	rows, err := r.db.Query(ctx, query, userId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]model.TimelineEntry, 0)
+
+	for rows.Next() {
+		var postId int
+		var publishedAt time.Time
+		if err := rows.Scan(&postId, &publishedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, model.TimelineEntry{PostId: postId, PublishedAt: publishedAt})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,124 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/juicyluv/astral/internal/model"
+	"go.uber.org/zap"
+)
+
+type IdentityRepository struct {
+	db     *pgxpool.Pool
+	logger *zap.SugaredLogger
+}
+
+func NewIdentityRepository(db *pgxpool.Pool, logger *zap.SugaredLogger) *IdentityRepository {
+	return &IdentityRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *IdentityRepository) FindUser(ctx context.Context, provider, subject string) (*model.User, error) {
+	var user model.User
+
+	query := `
+	SELECT u.user_id, u.username, u.email, u.is_verified, u.role, u.totp_enabled, u.totp_secret,
+	TO_CHAR(u.registered_at, 'DD-MM-YYYY') as registered_at
+	FROM user_identities i
+	INNER JOIN users u
+	ON u.user_id = i.user_id
+	WHERE i.provider = $1 AND i.subject = $2`
+
+	err := r.db.QueryRow(ctx, query, provider, subject).Scan(
+		&user.Id,
+		&user.Username,
+		&user.Email,
+		&user.IsVerified,
+		&user.Role,
+		&user.TotpEnabled,
+		&user.TotpSecret,
+		&user.RegisteredAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *IdentityRepository) Create(ctx context.Context, identity *model.UserIdentity) (int, error) {
+	query := `
+	INSERT INTO user_identities(user_id, provider, subject, email)
+	VALUES($1, $2, $3, $4)
+	RETURNING identity_id`
+
+	err := r.db.QueryRow(
+		ctx,
+		query,
+		identity.UserId,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+	).Scan(&identity.Id)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return identity.Id, nil
+}
+
+func (r *IdentityRepository) CreateWithUser(ctx context.Context, user *model.User, identity *model.UserIdentity) (int, error) {
+	query := `
+	INSERT INTO users(username, email, is_verified)
+	VALUES($1, $2, $3)
+	RETURNING user_id, role`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(
+		ctx,
+		query,
+		user.Username,
+		user.Email,
+		user.IsVerified,
+	).Scan(&user.Id, &user.Role)
+
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+	INSERT INTO user_identities(user_id, provider, subject, email)
+	VALUES($1, $2, $3, $4)
+	RETURNING identity_id`
+
+	err = tx.QueryRow(
+		ctx,
+		query,
+		user.Id,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+	).Scan(&identity.Id)
+
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	identity.UserId = user.Id
+
+	return user.Id, nil
+}
@@ -3,20 +3,67 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"html"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/juicyluv/astral/internal/handler/filter"
+	"github.com/juicyluv/astral/internal/markdown"
 	"github.com/juicyluv/astral/internal/model"
+	"github.com/juicyluv/astral/internal/store"
 	"go.uber.org/zap"
 )
 
+// publishScheduledLockId is the key of the advisory lock which
+// lets only one application instance publish scheduled posts
+const publishScheduledLockId = 7_261_637_401
+
+// Snippet matches are wrapped in private use characters, the snippet is
+// escaped and then they are replaced with marks. Such characters typed
+// in the content become marks too, but can't inject anything else.
+const (
+	snippetStartSel = "\uE000"
+	snippetStopSel  = "\uE001"
+)
+
+// snippetOptions are ts_headline options of search result snippets
+const snippetOptions = "StartSel=" + snippetStartSel + ", StopSel=" + snippetStopSel +
+	", MaxWords=35, MinWords=15, MaxFragments=2"
+
+// postTagsColumn selects slugs of the tags of the post aliased as p
+const postTagsColumn = `ARRAY(
+		SELECT t.slug FROM post_tags pt
+		INNER JOIN tags t ON t.tag_id = pt.tag_id
+		WHERE pt.post_id = p.post_id
+		ORDER BY t.slug
+	) as tags`
+
+// postAuthorsColumn selects authors of the post aliased as p who have
+// accepted the invitation, the owner of the post comes first
+const postAuthorsColumn = `(
+		SELECT COALESCE(json_agg(json_build_object('id', au.user_id, 'username', au.username)
+			ORDER BY au.user_id <> p.author_id, up.accepted_at), '[]')
+		FROM user_post up
+		INNER JOIN users au ON au.user_id = up.user_id
+		WHERE up.post_id = p.post_id AND up.accepted_at IS NOT NULL
+	) as authors`
+
+// isPostAuthorCondition checks whether the user with the id from
+// the given argument is an author of the post aliased as p
+const isPostAuthorCondition = `EXISTS(
+		SELECT 1 FROM user_post up
+		WHERE up.post_id = p.post_id AND up.user_id = $%d AND up.accepted_at IS NOT NULL
+	)`
+
 type PostRepository struct {
-	db     *pgx.Conn
+	db     *pgxpool.Pool
 	logger *zap.SugaredLogger
 }
 
-func NewPostRepository(db *pgx.Conn, logger *zap.SugaredLogger) *PostRepository {
+func NewPostRepository(db *pgxpool.Pool, logger *zap.SugaredLogger) *PostRepository {
 	return &PostRepository{
 		db:     db,
 		logger: logger,
@@ -25,86 +72,60 @@ func NewPostRepository(db *pgx.Conn, logger *zap.SugaredLogger) *PostRepository
 
 func (r *PostRepository) Create(ctx context.Context, post *model.Post) (int, error) {
 	query := `
-	INSERT INTO posts(title, content, author_id) 
-	VALUES($1, $2, $3)
+	INSERT INTO posts(title, content, format, content_html, author_id, status, published_at) 
+	VALUES($1, $2, $3, $4, $5, $6, $7)
 	RETURNING post_id`
 
 	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		return 0, err
 	}
+	defer tx.Rollback(ctx)
 
 	err = tx.QueryRow(
 		ctx,
 		query,
 		post.Title,
 		post.Content,
+		post.Format,
+		post.ContentHTML,
 		post.Author.Id,
+		post.Status,
+		post.PublishedAt,
 	).Scan(&post.Id)
 
 	if err != nil {
-		if err = tx.Rollback(ctx); err != nil {
-			return 0, err
-		}
 		return 0, err
 	}
 
-	query = `INSERT INTO user_post VALUES ($1, $2)`
+	query = `
+	INSERT INTO user_post(user_id, post_id, accepted_at)
+	VALUES ($1, $2, now())`
 	_, err = tx.Exec(ctx, query, post.Author.Id, post.Id)
 	if err != nil {
-		if err = tx.Rollback(ctx); err != nil {
-			return 0, err
-		}
 		return 0, err
 	}
 
-	err = tx.Commit(ctx)
+	err = replacePostTags(ctx, tx, post.Id, post.Tags)
 	if err != nil {
 		return 0, err
 	}
 
-	return post.Id, nil
-}
-
-func (r *PostRepository) FindAll(ctx context.Context, filter *filter.PostFilter) ([]model.Post, error) {
-	var posts []model.Post
-
-	query := `
-	SELECT 
-	p.post_id, p.title, p.content, 
-	TO_CHAR(p.created_at, 'DD-MM-YYYY') as created_at, 
-	TO_CHAR(p.updated_at, 'DD-MM-YYYY') as updated_at, 
-	u.user_id, u.username 
-	FROM posts p
-	INNER JOIN users u 
-	ON u.user_id = p.author_id
-	WHERE  (LOWER(p.title) = LOWER($1) OR $1 = '')
-	`
-
-	rows, err := r.db.Query(ctx, query, filter.Title)
+	err = createPostRevision(ctx, tx, post.Id, post.Author.Id)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var post model.Post
-		err := rows.Scan(
-			&post.Id,
-			&post.Title,
-			&post.Content,
-			&post.CreatedAt,
-			&post.UpdatedAt,
-			&post.Author.Id,
-			&post.Author.Username,
-		)
-		if err != nil {
-			return nil, err
-		}
-		posts = append(posts, post)
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
 	}
 
-	return posts, nil
+	return post.Id, nil
+}
+
+func (r *PostRepository) FindAll(ctx context.Context, filter *filter.PostFilter) ([]model.Post, *filter.Cursor, error) {
+	return r.findPosts(ctx, filter)
 }
 
 func (r *PostRepository) FindById(ctx context.Context, postId int) (*model.Post, error) {
@@ -112,10 +133,11 @@ func (r *PostRepository) FindById(ctx context.Context, postId int) (*model.Post,
 
 	query := `
 	SELECT 
-	p.post_id, p.title, p.content, 
+	p.post_id, p.title, p.content, p.format, p.content_html, 
 	TO_CHAR(p.created_at, 'DD-MM-YYYY') as created_at, 
 	TO_CHAR(p.updated_at, 'DD-MM-YYYY') as updated_at, 
-	u.user_id, u.username 
+	u.user_id, u.username, ` + postTagsColumn + `, p.reaction_counts,
+	p.status, p.published_at, p.version, ` + postAuthorsColumn + `
 	FROM posts p
 	INNER JOIN users u 
 	ON u.user_id = p.author_id
@@ -125,21 +147,31 @@ func (r *PostRepository) FindById(ctx context.Context, postId int) (*model.Post,
 		&post.Id,
 		&post.Title,
 		&post.Content,
+		&post.Format,
+		&post.ContentHTML,
 		&post.CreatedAt,
 		&post.UpdatedAt,
 		&post.Author.Id,
 		&post.Author.Username,
+		&post.Tags,
+		&post.Reactions,
+		&post.Status,
+		&post.PublishedAt,
+		&post.Version,
+		&post.Authors,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
+	renderMissingHTML(&post)
+
 	return &post, nil
 }
 
-func (r *PostRepository) Update(ctx context.Context, postId int, post *model.UpdatePostDto) error {
-	values := make([]string, 0)
+func (r *PostRepository) Update(ctx context.Context, postId, editorId int, post *model.UpdatePostDto) error {
+	values := []string{"updated_at=now()", "version=version+1"}
 	args := make([]interface{}, 0)
 	argId := 1
 
@@ -155,20 +187,255 @@ func (r *PostRepository) Update(ctx context.Context, postId int, post *model.Upd
 		argId++
 	}
 
+	if post.Format != nil {
+		values = append(values, fmt.Sprintf("format=$%d", argId))
+		args = append(args, *post.Format)
+		argId++
+	}
+
+	if post.ContentHTML != nil {
+		values = append(values, fmt.Sprintf("content_html=$%d", argId))
+		args = append(args, *post.ContentHTML)
+		argId++
+	}
+
 	if post.AuthorId != nil {
 		values = append(values, fmt.Sprintf("author_id=$%d", argId))
 		args = append(args, *post.AuthorId)
 		argId++
 	}
 
+	if post.Status != nil {
+		values = append(values, fmt.Sprintf("status=$%d", argId))
+		args = append(args, *post.Status)
+		argId++
+	}
+
+	if post.PublishedAt != nil {
+		values = append(values, fmt.Sprintf("published_at=$%d", argId))
+		args = append(args, *post.PublishedAt)
+		argId++
+	} else if post.Status != nil && *post.Status == model.PostStatusDraft {
+		values = append(values, "published_at=NULL")
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
 	valuesQuery := strings.Join(values, ", ")
 	query := fmt.Sprintf("UPDATE posts SET %s WHERE post_id = $%d", valuesQuery, argId)
 	args = append(args, postId)
 
-	_, err := r.db.Exec(ctx, query, args...)
+	if post.Version != nil {
+		query += fmt.Sprintf(" AND version = $%d", argId+1)
+		args = append(args, *post.Version)
+	}
+
+	result, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return updateMissError(ctx, tx, "posts", "post_id", postId, post.Version)
+	}
+
+	if post.Tags != nil {
+		if err = replacePostTags(ctx, tx, postId, *post.Tags); err != nil {
+			return err
+		}
+	}
+
+	// The new owner becomes an author of the post, the previous one stays a co-author
+	if post.AuthorId != nil {
+		query := `
+		INSERT INTO user_post(user_id, post_id, accepted_at)
+		VALUES ($1, $2, now())
+		ON CONFLICT (user_id, post_id) DO UPDATE
+		SET accepted_at = COALESCE(user_post.accepted_at, now())`
+
+		if _, err = tx.Exec(ctx, query, *post.AuthorId, postId); err != nil {
+			return err
+		}
+	}
+
+	if err = createPostRevision(ctx, tx, postId, editorId); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// createPostRevision saves the current title and content of the post
+// as its next revision. Concurrent updates of the post wait for the row
+// lock of the post, so revision numbers don't collide.
+func createPostRevision(ctx context.Context, tx pgx.Tx, postId, editorId int) error {
+	query := `
+	INSERT INTO post_revisions(post_id, revision, title, content, format, editor_id)
+	SELECT p.post_id, COALESCE(
+		(SELECT max(revision) FROM post_revisions WHERE post_id = p.post_id), 0
+	) + 1, p.title, p.content, p.format, $2
+	FROM posts p
+	WHERE p.post_id = $1`
+
+	_, err := tx.Exec(ctx, query, postId, editorId)
 	return err
 }
 
+func (r *PostRepository) FindRevisions(ctx context.Context, postId int) ([]model.PostRevision, error) {
+	query := `
+	SELECT pr.revision_id, pr.post_id, pr.revision, pr.title,
+	pr.format, pr.editor_id, u.username, pr.created_at
+	FROM post_revisions pr
+	LEFT JOIN users u
+	ON u.user_id = pr.editor_id
+	WHERE pr.post_id = $1
+	ORDER BY pr.revision DESC`
+
+	rows, err := r.db.Query(ctx, query, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]model.PostRevision, 0)
+
+	for rows.Next() {
+		var revision model.PostRevision
+		var editorId *int
+		var username *string
+
+		err := rows.Scan(
+			&revision.Id,
+			&revision.PostId,
+			&revision.Revision,
+			&revision.Title,
+			&revision.Format,
+			&editorId,
+			&username,
+			&revision.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		revision.Editor = revisionEditor(editorId, username)
+		revisions = append(revisions, revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (r *PostRepository) FindRevision(ctx context.Context, postId, revisionNumber int) (*model.PostRevision, error) {
+	var revision model.PostRevision
+	var editorId *int
+	var username *string
+
+	query := `
+	SELECT pr.revision_id, pr.post_id, pr.revision, pr.title, pr.content,
+	pr.format, pr.editor_id, u.username, pr.created_at
+	FROM post_revisions pr
+	LEFT JOIN users u
+	ON u.user_id = pr.editor_id
+	WHERE pr.post_id = $1 AND pr.revision = $2`
+
+	err := r.db.QueryRow(ctx, query, postId, revisionNumber).Scan(
+		&revision.Id,
+		&revision.PostId,
+		&revision.Revision,
+		&revision.Title,
+		&revision.Content,
+		&revision.Format,
+		&editorId,
+		&username,
+		&revision.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	revision.Editor = revisionEditor(editorId, username)
+
+	return &revision, nil
+}
+
+// revisionEditor returns the editor of the revision, it is nil
+// when the editor account has been deleted
+func revisionEditor(editorId *int, username *string) *model.User {
+	if editorId == nil {
+		return nil
+	}
+
+	editor := &model.User{Id: *editorId}
+	if username != nil {
+		editor.Username = *username
+	}
+
+	return editor
+}
+
+func (r *PostRepository) InviteAuthor(ctx context.Context, postId, userId, inviterId int) error {
+	query := `
+	INSERT INTO user_post(user_id, post_id, invited_by)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, post_id) DO NOTHING`
+
+	result, err := r.db.Exec(ctx, query, userId, postId, inviterId)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return store.ErrAlreadyInvited
+	}
+
+	return nil
+}
+
+func (r *PostRepository) AcceptAuthor(ctx context.Context, postId, userId int) error {
+	query := `
+	UPDATE user_post
+	SET accepted_at = now()
+	WHERE post_id = $1 AND user_id = $2 AND accepted_at IS NULL`
+
+	result, err := r.db.Exec(ctx, query, postId, userId)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *PostRepository) RemoveAuthor(ctx context.Context, postId, userId int) error {
+	// The owner of the post can't be removed
+	query := `
+	DELETE FROM user_post up
+	USING posts p
+	WHERE p.post_id = up.post_id AND up.post_id = $1 AND up.user_id = $2
+	AND p.author_id <> up.user_id`
+
+	result, err := r.db.Exec(ctx, query, postId, userId)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
 func (r *PostRepository) Delete(ctx context.Context, postId int) error {
 	query := `
 	DELETE FROM posts
@@ -182,36 +449,403 @@ func (r *PostRepository) Delete(ctx context.Context, postId int) error {
 	return nil
 }
 
-func (r *PostRepository) FindUserPosts(ctx context.Context, userId int) ([]model.Post, error) {
-	var posts []model.Post
+func (r *PostRepository) FindUserPosts(ctx context.Context, userId int, filter *filter.PostFilter) ([]model.Post, *filter.Cursor, error) {
+	userFilter := *filter
+	userFilter.AuthorId = userId
 
-	query := `
+	return r.findPosts(ctx, &userFilter)
+}
+
+// FindFeed returns a page of published posts of the users the user follows,
+// the latest first. It is used when the timeline of the user isn't cached.
+func (r *PostRepository) FindFeed(ctx context.Context, userId int, page *filter.PageFilter) ([]model.Post, *filter.Cursor, error) {
+	return r.findPosts(ctx, &filter.PostFilter{
+		FollowerId: userId,
+		Sort:       filter.SortPublishedAt,
+		Order:      filter.OrderDesc,
+		Limit:      page.Limit,
+		After:      page.After,
+	})
+}
+
+func (r *PostRepository) FindByIds(ctx context.Context, postIds []int) ([]model.Post, error) {
+	if len(postIds) == 0 {
+		return []model.Post{}, nil
+	}
+
+	posts, _, err := r.findPosts(ctx, &filter.PostFilter{
+		Ids:   postIds,
+		Sort:  filter.SortPublishedAt,
+		Order: filter.OrderDesc,
+		Limit: len(postIds),
+	})
+
+	return posts, err
+}
+
+// findPosts returns a page of posts which match the filter and the cursor
+// of the last post if there are more posts to fetch. Posts are fetched
+// by the keyset, so pages are stable and deep pages are as cheap as the first one.
+func (r *PostRepository) findPosts(ctx context.Context, f *filter.PostFilter) ([]model.Post, *filter.Cursor, error) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	argId := 1
+
+	// Sort column is taken from the whitelist, so it's safe to put it in the query
+	sortColumn := "p.created_at"
+	switch f.Sort {
+	case filter.SortUpdatedAt:
+		sortColumn = "p.updated_at"
+	case filter.SortPublishedAt:
+		sortColumn = "p.published_at"
+	}
+
+	direction, comparison := "DESC", "<"
+	if f.Order == filter.OrderAsc {
+		direction, comparison = "ASC", ">"
+	}
+
+	// Posts which are not published are listed only for the author
+	if f.Status == "" || f.Status == model.PostStatusPublished {
+		conditions = append(conditions, "p.status = 'published'")
+	} else {
+		conditions = append(conditions, fmt.Sprintf("p.status = $%d AND "+isPostAuthorCondition, argId, argId+1))
+		args = append(args, f.Status, f.ViewerId)
+		argId += 2
+	}
+
+	if f.Title != "" {
+		conditions = append(conditions, fmt.Sprintf("p.title ILIKE '%%' || $%d || '%%'", argId))
+		args = append(args, escapeLike(f.Title))
+		argId++
+	}
+
+	// Co-authored posts are listed for every author
+	if f.AuthorId != 0 {
+		conditions = append(conditions, fmt.Sprintf(isPostAuthorCondition, argId))
+		args = append(args, f.AuthorId)
+		argId++
+	}
+
+	if f.FollowerId != 0 {
+		conditions = append(conditions, fmt.Sprintf(`EXISTS(
+		SELECT 1 FROM user_post up
+		INNER JOIN follows f ON f.followee_id = up.user_id
+		WHERE up.post_id = p.post_id AND up.accepted_at IS NOT NULL AND f.follower_id = $%d
+	)`, argId))
+		args = append(args, f.FollowerId)
+		argId++
+	}
+
+	if len(f.Ids) > 0 {
+		conditions = append(conditions, fmt.Sprintf("p.post_id = ANY($%d)", argId))
+		args = append(args, f.Ids)
+		argId++
+	}
+
+	if f.CreatedFrom != nil {
+		conditions = append(conditions, fmt.Sprintf("p.created_at >= $%d", argId))
+		args = append(args, *f.CreatedFrom)
+		argId++
+	}
+
+	if f.CreatedTo != nil {
+		conditions = append(conditions, fmt.Sprintf("p.created_at < $%d", argId))
+		args = append(args, f.CreatedTo.AddDate(0, 0, 1))
+		argId++
+	}
+
+	if len(f.Tags) > 0 {
+		tagged := fmt.Sprintf(`
+		SELECT count(*) FROM post_tags pt
+		INNER JOIN tags t ON t.tag_id = pt.tag_id
+		WHERE pt.post_id = p.post_id AND t.slug = ANY($%d)`, argId)
+		args = append(args, f.Tags)
+		argId++
+
+		if f.TagMatch == filter.TagMatchAll {
+			conditions = append(conditions, fmt.Sprintf("(%s) = $%d", tagged, argId))
+			args = append(args, len(f.Tags))
+			argId++
+		} else {
+			conditions = append(conditions, fmt.Sprintf("(%s) > 0", tagged))
+		}
+	}
+
+	if f.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(%s, p.post_id) %s ($%d, $%d)", sortColumn, comparison, argId, argId+1))
+		args = append(args, f.After.Time, f.After.Id)
+		argId += 2
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// One more post is fetched to find out whether there is the next page
+	query := fmt.Sprintf(`
 	SELECT 
-	p.post_id, p.title, p.content, 
+	p.post_id, p.title, p.content, p.format, p.content_html, 
 	TO_CHAR(p.created_at, 'DD-MM-YYYY') as created_at, 
 	TO_CHAR(p.updated_at, 'DD-MM-YYYY') as updated_at, 
-	u.user_id, u.username 
+	u.user_id, u.username, %[5]s, p.reaction_counts,
+	p.status, p.published_at, %[6]s, %[1]s
 	FROM posts p
 	INNER JOIN users u 
 	ON u.user_id = p.author_id
-	WHERE p.author_id = $1`
+	%[2]s
+	ORDER BY %[1]s %[3]s, p.post_id %[3]s
+	LIMIT $%[4]d`, sortColumn, where, direction, argId, postTagsColumn, postAuthorsColumn)
+	args = append(args, f.Limit+1)
 
-	rows, err := r.db.Query(ctx, query, userId)
+	rows, err := r.db.Query(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
+	posts := make([]model.Post, 0, f.Limit)
+	var next *filter.Cursor
+	var lastSortValue time.Time
+
 	for rows.Next() {
 		var post model.Post
+		var sortValue time.Time
 		err := rows.Scan(
 			&post.Id,
 			&post.Title,
 			&post.Content,
+			&post.Format,
+			&post.ContentHTML,
 			&post.CreatedAt,
 			&post.UpdatedAt,
 			&post.Author.Id,
 			&post.Author.Username,
+			&post.Tags,
+			&post.Reactions,
+			&post.Status,
+			&post.PublishedAt,
+			&post.Authors,
+			&sortValue,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(posts) == f.Limit {
+			next = &filter.Cursor{Time: lastSortValue, Id: posts[len(posts)-1].Id, Sort: f.Sort, Order: f.Order}
+			break
+		}
+
+		renderMissingHTML(&post)
+		posts = append(posts, post)
+		lastSortValue = sortValue
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return posts, next, nil
+}
+
+// renderMissingHTML renders the content of posts which have been created
+// before the content was rendered on write. They are plain text and their
+// HTML is left empty by the migration, so it is rendered the same way as
+// the content of new posts.
+func renderMissingHTML(post *model.Post) {
+	if post.ContentHTML == "" && post.Format == model.PostFormatPlain {
+		post.ContentHTML = markdown.RenderPlain(post.Content)
+	}
+}
+
+// escapeLike escapes LIKE pattern characters, so the value is matched literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+func (r *PostRepository) Search(ctx context.Context, f *filter.SearchFilter) ([]model.PostSearchResult, bool, error) {
+	tsquery, args := buildTsquery(f.Query)
+	if tsquery == "" {
+		return []model.PostSearchResult{}, false, nil
+	}
+	argId := len(args) + 1
+
+	// Snippets are expensive to build, so they are built only for the page.
+	// One more post is fetched to find out whether there is the next page.
+	query := fmt.Sprintf(`
+	SELECT 
+	p.post_id, p.title, p.content, p.format, p.content_html, 
+	TO_CHAR(p.created_at, 'DD-MM-YYYY') as created_at, 
+	TO_CHAR(p.updated_at, 'DD-MM-YYYY') as updated_at, 
+	u.user_id, u.username, %s, p.reaction_counts,
+	p.status, p.published_at, %s,
+	ts_headline('english', p.content, p.query, $%d),
+	p.rank
+	FROM (
+		SELECT posts.*, q.query, ts_rank(posts.search_vector, q.query) as rank
+		FROM posts, (SELECT %s as query) q
+		WHERE posts.search_vector @@ q.query AND posts.status = 'published'
+		ORDER BY rank DESC, posts.post_id DESC
+		LIMIT $%d OFFSET $%d
+	) p
+	INNER JOIN users u 
+	ON u.user_id = p.author_id
+	ORDER BY p.rank DESC, p.post_id DESC`, postTagsColumn, postAuthorsColumn, argId+2, tsquery, argId, argId+1)
+	args = append(args, f.Limit+1, f.Offset, snippetOptions)
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	results := make([]model.PostSearchResult, 0, f.Limit)
+
+	for rows.Next() {
+		var result model.PostSearchResult
+		err := rows.Scan(
+			&result.Id,
+			&result.Title,
+			&result.Content,
+			&result.Format,
+			&result.ContentHTML,
+			&result.CreatedAt,
+			&result.UpdatedAt,
+			&result.Author.Id,
+			&result.Author.Username,
+			&result.Tags,
+			&result.Reactions,
+			&result.Status,
+			&result.PublishedAt,
+			&result.Authors,
+			&result.Snippet,
+			&result.Rank,
+		)
+		if err != nil {
+			return nil, false, err
+		}
+
+		result.Snippet = markSnippet(result.Snippet)
+		renderMissingHTML(&result.Post)
+		results = append(results, result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(results) > f.Limit {
+		return results[:f.Limit], true, nil
+	}
+
+	return results, false, nil
+}
+
+// markSnippet escapes the snippet, so it is safe to show as HTML,
+// and wraps the matches in <mark> tags
+func markSnippet(snippet string) string {
+	return strings.NewReplacer(
+		snippetStartSel, "<mark>",
+		snippetStopSel, "</mark>",
+	).Replace(html.EscapeString(snippet))
+}
+
+// buildTsquery builds tsquery expression from the search query and returns
+// it with its arguments. Every term must match. Quoted text is matched as
+// a phrase and a word ending with * is matched as a prefix. Terms are passed
+// as arguments, so the expression is safe to put in the query.
+func buildTsquery(search string) (string, []interface{}) {
+	parts := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	for _, term := range splitSearchTerms(search) {
+		argId := len(args) + 1
+
+		switch {
+		case strings.Contains(term, " "):
+			parts = append(parts, fmt.Sprintf("phraseto_tsquery('english', $%d)", argId))
+			args = append(args, term)
+		case strings.HasSuffix(term, "*"):
+			// Only letters and digits are left, so the word can't
+			// break the to_tsquery syntax
+			word := strings.Map(func(r rune) rune {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, term)
+			if word == "" {
+				continue
+			}
+			parts = append(parts, fmt.Sprintf("to_tsquery('english', $%d || ':*')", argId))
+			args = append(args, word)
+		default:
+			parts = append(parts, fmt.Sprintf("plainto_tsquery('english', $%d)", argId))
+			args = append(args, term)
+		}
+	}
+
+	return strings.Join(parts, " && "), args
+}
+
+// splitSearchTerms splits the search query by spaces. Text in double
+// quotes is kept as a single term.
+func splitSearchTerms(search string) []string {
+	terms := make([]string, 0)
+
+	for i, part := range strings.Split(search, `"`) {
+		// Odd parts are inside quotes
+		if i%2 == 1 {
+			if phrase := strings.Join(strings.Fields(part), " "); phrase != "" {
+				terms = append(terms, phrase)
+			}
+			continue
+		}
+
+		terms = append(terms, strings.Fields(part)...)
+	}
+
+	return terms
+}
+
+func (r *PostRepository) PublishScheduled(ctx context.Context) ([]model.Post, error) {
+	query := `
+	UPDATE posts
+	SET status = 'published'
+	WHERE status = 'scheduled' AND published_at <= now()
+	RETURNING post_id, title, author_id, status, published_at`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Another instance is publishing posts right now
+	var locked bool
+	err = tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", publishScheduledLockId).Scan(&locked)
+	if err != nil || !locked {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]model.Post, 0)
+
+	for rows.Next() {
+		var post model.Post
+		err := rows.Scan(
+			&post.Id,
+			&post.Title,
+			&post.Author.Id,
+			&post.Status,
+			&post.PublishedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -219,5 +853,41 @@ func (r *PostRepository) FindUserPosts(ctx context.Context, userId int) ([]model
 		posts = append(posts, post)
 	}
 
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
 	return posts, nil
 }
+
+// replacePostTags sets the tags of the post. Tags which don't exist yet are created.
+func replacePostTags(ctx context.Context, tx pgx.Tx, postId int, tags []string) error {
+	query := `DELETE FROM post_tags WHERE post_id = $1`
+	if _, err := tx.Exec(ctx, query, postId); err != nil {
+		return err
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	query = `
+	INSERT INTO tags(slug)
+	SELECT unnest($1::text[])
+	ON CONFLICT(slug) DO NOTHING`
+	if _, err := tx.Exec(ctx, query, tags); err != nil {
+		return err
+	}
+
+	query = `
+	INSERT INTO post_tags(post_id, tag_id)
+	SELECT $1, tag_id FROM tags
+	WHERE slug = ANY($2)`
+	_, err := tx.Exec(ctx, query, postId, tags)
+
+	return err
+}
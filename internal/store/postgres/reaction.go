@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.uber.org/zap"
+)
+
+// reactionTarget describes tables of the entity users react to
+type reactionTarget struct {
+	// table stores the reaction_counts column
+	table string
+	// reactions stores reactions of users
+	reactions string
+	// idColumn is the id column in both tables
+	idColumn string
+}
+
+var (
+	postReactions    = reactionTarget{table: "posts", reactions: "post_reactions", idColumn: "post_id"}
+	commentReactions = reactionTarget{table: "comments", reactions: "comment_reactions", idColumn: "comment_id"}
+)
+
+type ReactionRepository struct {
+	db     *pgxpool.Pool
+	logger *zap.SugaredLogger
+}
+
+func NewReactionRepository(db *pgxpool.Pool, logger *zap.SugaredLogger) *ReactionRepository {
+	return &ReactionRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *ReactionRepository) AddPostReaction(ctx context.Context, postId, userId int, kind string) error {
+	return r.add(ctx, postReactions, postId, userId, kind)
+}
+
+func (r *ReactionRepository) RemovePostReaction(ctx context.Context, postId, userId int, kind string) error {
+	return r.remove(ctx, postReactions, postId, userId, kind)
+}
+
+func (r *ReactionRepository) FindUserPostReactions(ctx context.Context, userId int, postIds []int) (map[int][]string, error) {
+	return r.findUserReactions(ctx, postReactions, userId, postIds)
+}
+
+func (r *ReactionRepository) AddCommentReaction(ctx context.Context, commentId, userId int, kind string) error {
+	return r.add(ctx, commentReactions, commentId, userId, kind)
+}
+
+func (r *ReactionRepository) RemoveCommentReaction(ctx context.Context, commentId, userId int, kind string) error {
+	return r.remove(ctx, commentReactions, commentId, userId, kind)
+}
+
+func (r *ReactionRepository) FindUserCommentReactions(ctx context.Context, userId int, commentIds []int) (map[int][]string, error) {
+	return r.findUserReactions(ctx, commentReactions, userId, commentIds)
+}
+
+// add saves the reaction and increments its counter. Adding
+// the same reaction again doesn't change anything.
+func (r *ReactionRepository) add(ctx context.Context, target reactionTarget, id, userId int, kind string) error {
+	query := fmt.Sprintf(`
+	INSERT INTO %s(%s, user_id, kind)
+	VALUES($1, $2, $3)
+	ON CONFLICT DO NOTHING`, target.reactions, target.idColumn)
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, query, id, userId, kind)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	if err = updateReactionCount(ctx, tx, target, id, kind, 1); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// remove deletes the reaction and decrements its counter. It returns
+// pgx.ErrNoRows if the user hasn't left the reaction.
+func (r *ReactionRepository) remove(ctx context.Context, target reactionTarget, id, userId int, kind string) error {
+	query := fmt.Sprintf(`
+	DELETE FROM %s
+	WHERE %s = $1 AND user_id = $2 AND kind = $3`, target.reactions, target.idColumn)
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, query, id, userId, kind)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	if err = updateReactionCount(ctx, tx, target, id, kind, -1); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *ReactionRepository) findUserReactions(ctx context.Context, target reactionTarget, userId int, ids []int) (map[int][]string, error) {
+	query := fmt.Sprintf(`
+	SELECT %[2]s, array_agg(kind ORDER BY kind)
+	FROM %[1]s
+	WHERE user_id = $1 AND %[2]s = ANY($2)
+	GROUP BY %[2]s`, target.reactions, target.idColumn)
+
+	rows, err := r.db.Query(ctx, query, userId, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reactions := make(map[int][]string)
+
+	for rows.Next() {
+		var id int
+		var kinds []string
+		if err := rows.Scan(&id, &kinds); err != nil {
+			return nil, err
+		}
+		reactions[id] = kinds
+	}
+
+	return reactions, rows.Err()
+}
+
+// updateReactionCount adds delta to the reaction counter. Counters
+// are kept in the row, so listings don't have to count reactions.
+// Counters which drop to zero are removed.
+func updateReactionCount(ctx context.Context, tx pgx.Tx, target reactionTarget, id int, kind string, delta int) error {
+	query := fmt.Sprintf(`
+	UPDATE %s
+	SET reaction_counts = CASE
+		WHEN COALESCE((reaction_counts->>$2::text)::int, 0) + $3 > 0
+		THEN jsonb_set(reaction_counts, ARRAY[$2::text], to_jsonb(COALESCE((reaction_counts->>$2::text)::int, 0) + $3))
+		ELSE reaction_counts - $2::text
+	END
+	WHERE %s = $1`, target.table, target.idColumn)
+
+	_, err := tx.Exec(ctx, query, id, kind, delta)
+	return err
+}
@@ -2,23 +2,39 @@ package postgres
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/juicyluv/astral/internal/store"
 	"go.uber.org/zap"
 )
 
 type Store struct {
-	user store.UserRepository
-	post store.PostRepository
-	db   *pgx.Conn
+	user       store.UserRepository
+	post       store.PostRepository
+	tag        store.TagRepository
+	comment    store.CommentRepository
+	reaction   store.ReactionRepository
+	follow     store.FollowRepository
+	attachment store.AttachmentRepository
+	token      store.TokenRepository
+	identity   store.IdentityRepository
+	db         *pgxpool.Pool
 }
 
-func NewPostgres(conn *pgx.Conn, logger *zap.SugaredLogger) *Store {
+func NewPostgres(conn *pgxpool.Pool, logger *zap.SugaredLogger) *Store {
 	return &Store{
-		db:   conn,
-		user: NewUserRepository(conn, logger),
-		post: NewPostRepository(conn, logger),
+		db:         conn,
+		user:       NewUserRepository(conn, logger),
+		post:       NewPostRepository(conn, logger),
+		tag:        NewTagRepository(conn, logger),
+		comment:    NewCommentRepository(conn, logger),
+		reaction:   NewReactionRepository(conn, logger),
+		follow:     NewFollowRepository(conn, logger),
+		attachment: NewAttachmentRepository(conn, logger),
+		token:      NewTokenRepository(conn, logger),
+		identity:   NewIdentityRepository(conn, logger),
 	}
 }
 
@@ -30,6 +46,61 @@ func (s *Store) Post() store.PostRepository {
 	return s.post
 }
 
+func (s *Store) Tag() store.TagRepository {
+	return s.tag
+}
+
+func (s *Store) Comment() store.CommentRepository {
+	return s.comment
+}
+
+func (s *Store) Reaction() store.ReactionRepository {
+	return s.reaction
+}
+
+func (s *Store) Follow() store.FollowRepository {
+	return s.follow
+}
+
+func (s *Store) Attachment() store.AttachmentRepository {
+	return s.attachment
+}
+
+func (s *Store) Token() store.TokenRepository {
+	return s.token
+}
+
+func (s *Store) Identity() store.IdentityRepository {
+	return s.identity
+}
+
 func (s *Store) Close(ctx context.Context) error {
-	return s.db.Close(ctx)
+	s.db.Close()
+	return nil
+}
+
+// queryRower is implemented by both the pool and transactions
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// updateMissError tells why the update of the record has affected no rows.
+// It returns store.ErrVersionConflict when the record exists but its version
+// differs from the expected one, and pgx.ErrNoRows when there is no record.
+func updateMissError(ctx context.Context, db queryRower, table, idColumn string, id int, version *int) error {
+	if version == nil {
+		return pgx.ErrNoRows
+	}
+
+	var exists bool
+	query := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE %s = $1)", table, idColumn)
+	if err := db.QueryRow(ctx, query, id).Scan(&exists); err != nil {
+		return err
+	}
+
+	if exists {
+		return store.ErrVersionConflict
+	}
+
+	return pgx.ErrNoRows
 }
@@ -0,0 +1,73 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/juicyluv/astral/internal/model"
+	"go.uber.org/zap"
+)
+
+type TagRepository struct {
+	db     *pgxpool.Pool
+	logger *zap.SugaredLogger
+}
+
+func NewTagRepository(db *pgxpool.Pool, logger *zap.SugaredLogger) *TagRepository {
+	return &TagRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *TagRepository) FindAll(ctx context.Context) ([]model.Tag, error) {
+	query := `
+	SELECT t.slug, count(pt.post_id) as post_count
+	FROM tags t
+	INNER JOIN post_tags pt
+	ON pt.tag_id = t.tag_id
+	INNER JOIN posts p
+	ON p.post_id = pt.post_id AND p.status = 'published'
+	GROUP BY t.slug
+	ORDER BY post_count DESC, t.slug`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]model.Tag, 0)
+
+	for rows.Next() {
+		var tag model.Tag
+		if err := rows.Scan(&tag.Slug, &tag.PostCount); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+func (r *TagRepository) FindBySlug(ctx context.Context, slug string) (*model.Tag, error) {
+	query := `
+	SELECT t.slug, count(pt.post_id) as post_count
+	FROM tags t
+	LEFT JOIN post_tags pt
+	ON pt.tag_id = t.tag_id AND EXISTS(
+		SELECT 1 FROM posts p
+		WHERE p.post_id = pt.post_id AND p.status = 'published'
+	)
+	WHERE t.slug = $1
+	GROUP BY t.slug`
+
+	var tag model.Tag
+
+	err := r.db.QueryRow(ctx, query, slug).Scan(&tag.Slug, &tag.PostCount)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
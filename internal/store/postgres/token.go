@@ -0,0 +1,133 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/juicyluv/astral/internal/model"
+	"go.uber.org/zap"
+)
+
+type TokenRepository struct {
+	db     *pgxpool.Pool
+	logger *zap.SugaredLogger
+}
+
+func NewTokenRepository(db *pgxpool.Pool, logger *zap.SugaredLogger) *TokenRepository {
+	return &TokenRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *TokenRepository) Create(ctx context.Context, token *model.PersonalAccessToken) (int, error) {
+	query := `
+	INSERT INTO personal_access_tokens(user_id, name, token_hash, scopes, expires_at)
+	VALUES($1, $2, $3, $4, $5)
+	RETURNING token_id, created_at`
+
+	err := r.db.QueryRow(
+		ctx,
+		query,
+		token.UserId,
+		token.Name,
+		token.TokenHash,
+		token.Scopes,
+		token.ExpiresAt,
+	).Scan(&token.Id, &token.CreatedAt)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return token.Id, nil
+}
+
+func (r *TokenRepository) FindUserTokens(ctx context.Context, userId int) ([]model.PersonalAccessToken, error) {
+	tokens := []model.PersonalAccessToken{}
+
+	query := `
+	SELECT token_id, user_id, name, scopes, created_at, expires_at, last_used_at
+	FROM personal_access_tokens
+	WHERE user_id = $1
+	ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token model.PersonalAccessToken
+		err := rows.Scan(
+			&token.Id,
+			&token.UserId,
+			&token.Name,
+			&token.Scopes,
+			&token.CreatedAt,
+			&token.ExpiresAt,
+			&token.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (r *TokenRepository) FindByHash(ctx context.Context, hash string) (*model.PersonalAccessToken, error) {
+	var token model.PersonalAccessToken
+
+	query := `
+	SELECT token_id, user_id, name, scopes, created_at, expires_at, last_used_at
+	FROM personal_access_tokens
+	WHERE token_hash = $1`
+
+	err := r.db.QueryRow(ctx, query, hash).Scan(
+		&token.Id,
+		&token.UserId,
+		&token.Name,
+		&token.Scopes,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (r *TokenRepository) Touch(ctx context.Context, tokenId int) error {
+	query := `
+	UPDATE personal_access_tokens
+	SET last_used_at = now()
+	WHERE token_id = $1`
+
+	_, err := r.db.Exec(ctx, query, tokenId)
+
+	return err
+}
+
+func (r *TokenRepository) Delete(ctx context.Context, tokenId, userId int) error {
+	query := `
+	DELETE FROM personal_access_tokens
+	WHERE token_id = $1 AND user_id = $2`
+
+	tag, err := r.db.Exec(ctx, query, tokenId, userId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
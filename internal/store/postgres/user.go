@@ -6,16 +6,18 @@ import (
 	"strings"
 
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/juicyluv/astral/internal/model"
+	"github.com/juicyluv/astral/internal/store"
 	"go.uber.org/zap"
 )
 
 type UserRepository struct {
-	db     *pgx.Conn
+	db     *pgxpool.Pool
 	logger *zap.SugaredLogger
 }
 
-func NewUserRepository(db *pgx.Conn, logger *zap.SugaredLogger) *UserRepository {
+func NewUserRepository(db *pgxpool.Pool, logger *zap.SugaredLogger) *UserRepository {
 	return &UserRepository{
 		db:     db,
 		logger: logger,
@@ -47,8 +49,9 @@ func (r *UserRepository) FindAll(ctx context.Context) ([]model.User, error) {
 	var users []model.User
 
 	query := `
-	SELECT user_id, username, email, is_verified,
-	TO_CHAR(registered_at, 'DD-MM-YYYY') as registered_at
+	SELECT user_id, username, email, is_verified, role, totp_enabled,
+	TO_CHAR(registered_at, 'DD-MM-YYYY') as registered_at,
+	display_name, bio, location, links, avatar
 	FROM users`
 
 	rows, err := r.db.Query(ctx, query)
@@ -59,16 +62,25 @@ func (r *UserRepository) FindAll(ctx context.Context) ([]model.User, error) {
 
 	for rows.Next() {
 		var user model.User
+		var avatar *string
 		err := rows.Scan(
 			&user.Id,
 			&user.Username,
 			&user.Email,
 			&user.IsVerified,
+			&user.Role,
+			&user.TotpEnabled,
 			&user.RegisteredAt,
+			&user.DisplayName,
+			&user.Bio,
+			&user.Location,
+			&user.Links,
+			&avatar,
 		)
 		if err != nil {
 			return nil, err
 		}
+		setAvatar(&user, avatar)
 		user.ClearPassword()
 		users = append(users, user)
 	}
@@ -80,23 +92,36 @@ func (r *UserRepository) FindById(ctx context.Context, userId int) (*model.User,
 	var user model.User
 
 	query := `
-	SELECT user_id, username, email, is_verified, 
-	TO_CHAR(registered_at, 'DD-MM-YYYY') as registered_at
+	SELECT user_id, username, email, is_verified, role, totp_enabled, totp_secret,
+	TO_CHAR(registered_at, 'DD-MM-YYYY') as registered_at, version,
+	display_name, bio, location, links, avatar
 	FROM users
 	WHERE user_id = $1`
 
+	var avatar *string
 	err := r.db.QueryRow(ctx, query, userId).Scan(
 		&user.Id,
 		&user.Username,
 		&user.Email,
 		&user.IsVerified,
+		&user.Role,
+		&user.TotpEnabled,
+		&user.TotpSecret,
 		&user.RegisteredAt,
+		&user.Version,
+		&user.DisplayName,
+		&user.Bio,
+		&user.Location,
+		&user.Links,
+		&avatar,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
+	setAvatar(&user, avatar)
+
 	user.ClearPassword()
 
 	return &user, nil
@@ -106,9 +131,9 @@ func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*model.
 	var user model.User
 
 	query := `
-	SELECT user_id, username, email, is_verified,
+	SELECT user_id, username, email, is_verified, role, totp_enabled, totp_secret,
 	TO_CHAR(registered_at, 'DD-MM-YYYY') as registered_at,
-	password
+	COALESCE(password, '')
 	FROM users
 	WHERE email = $1`
 
@@ -117,6 +142,9 @@ func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*model.
 		&user.Username,
 		&user.Email,
 		&user.IsVerified,
+		&user.Role,
+		&user.TotpEnabled,
+		&user.TotpSecret,
 		&user.RegisteredAt,
 		&user.Password,
 	)
@@ -129,16 +157,10 @@ func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*model.
 }
 
 func (r *UserRepository) Update(ctx context.Context, userId int, user *model.UpdateUserDto) error {
-	values := make([]string, 0)
+	values := []string{"version=version+1"}
 	args := make([]interface{}, 0)
 	argId := 1
 
-	if user.Email != nil {
-		values = append(values, fmt.Sprintf("email=$%d", argId))
-		args = append(args, *user.Email)
-		argId++
-	}
-
 	if user.Username != nil {
 		values = append(values, fmt.Sprintf("username=$%d", argId))
 		args = append(args, *user.Username)
@@ -157,12 +179,73 @@ func (r *UserRepository) Update(ctx context.Context, userId int, user *model.Upd
 		argId++
 	}
 
+	if user.Role != nil {
+		values = append(values, fmt.Sprintf("role=$%d", argId))
+		args = append(args, *user.Role)
+		argId++
+	}
+
+	if user.DisplayName != nil {
+		values = append(values, fmt.Sprintf("display_name=$%d", argId))
+		args = append(args, *user.DisplayName)
+		argId++
+	}
+
+	if user.Bio != nil {
+		values = append(values, fmt.Sprintf("bio=$%d", argId))
+		args = append(args, *user.Bio)
+		argId++
+	}
+
+	if user.Location != nil {
+		values = append(values, fmt.Sprintf("location=$%d", argId))
+		args = append(args, *user.Location)
+		argId++
+	}
+
+	if user.Links != nil {
+		values = append(values, fmt.Sprintf("links=$%d", argId))
+		args = append(args, *user.Links)
+		argId++
+	}
+
 	valuesQuery := strings.Join(values, ", ")
 	query := fmt.Sprintf("UPDATE users SET %s WHERE user_id = $%d", valuesQuery, argId)
 	args = append(args, userId)
 
-	_, err := r.db.Exec(ctx, query, args...)
-	return err
+	if user.Version != nil {
+		query += fmt.Sprintf(" AND version = $%d", argId+1)
+		args = append(args, *user.Version)
+	}
+
+	result, err := r.db.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return updateMissError(ctx, r.db, "users", "user_id", userId, user.Version)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) SetAvatar(ctx context.Context, userId int, hash string) error {
+	query := `
+	UPDATE users
+	SET avatar = $1, version = version + 1
+	WHERE user_id = $2`
+
+	result, err := r.db.Exec(ctx, query, hash, userId)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
 }
 
 func (r *UserRepository) Delete(ctx context.Context, userId int) error {
@@ -188,3 +271,229 @@ func (r *UserRepository) ConfirmEmail(ctx context.Context, userId int) error {
 
 	return err
 }
+
+func (r *UserRepository) SetTotpSecret(ctx context.Context, userId int, secret []byte) error {
+	query := `
+	UPDATE users
+	SET totp_secret = $1, totp_enabled = false
+	WHERE user_id = $2`
+
+	_, err := r.db.Exec(ctx, query, secret, userId)
+
+	return err
+}
+
+func (r *UserRepository) EnableTotp(ctx context.Context, userId int, recoveryCodes []string) error {
+	query := `
+	UPDATE users
+	SET totp_enabled = true
+	WHERE user_id = $1 AND totp_secret IS NOT NULL`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, query, userId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	if err = replaceRecoveryCodes(ctx, tx, userId, recoveryCodes); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *UserRepository) DisableTotp(ctx context.Context, userId int) error {
+	query := `
+	UPDATE users
+	SET totp_secret = NULL, totp_enabled = false
+	WHERE user_id = $1`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, query, userId); err != nil {
+		return err
+	}
+
+	if err = replaceRecoveryCodes(ctx, tx, userId, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *UserRepository) ReplaceRecoveryCodes(ctx context.Context, userId int, recoveryCodes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = replaceRecoveryCodes(ctx, tx, userId, recoveryCodes); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *UserRepository) UseRecoveryCode(ctx context.Context, userId int, recoveryCode string) error {
+	query := `
+	UPDATE recovery_codes
+	SET used_at = now()
+	WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	tag, err := r.db.Exec(ctx, query, userId, recoveryCode)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// replaceRecoveryCodes removes every recovery code of the user
+// and inserts the given ones within the transaction.
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userId int, recoveryCodes []string) error {
+	query := `
+	DELETE FROM recovery_codes
+	WHERE user_id = $1`
+
+	if _, err := tx.Exec(ctx, query, userId); err != nil {
+		return err
+	}
+
+	query = `
+	INSERT INTO recovery_codes(user_id, code_hash)
+	VALUES($1, $2)`
+
+	for _, code := range recoveryCodes {
+		if _, err := tx.Exec(ctx, query, userId, code); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *UserRepository) CreateEmailChange(ctx context.Context, change *model.EmailChange) error {
+	query := `
+	INSERT INTO email_changes(user_id, new_email, token_hash, cancel_token_hash, expires_at)
+	VALUES($1, $2, $3, $4, $5)
+	ON CONFLICT(user_id) DO UPDATE
+	SET new_email = EXCLUDED.new_email,
+		token_hash = EXCLUDED.token_hash,
+		cancel_token_hash = EXCLUDED.cancel_token_hash,
+		created_at = now(),
+		expires_at = EXCLUDED.expires_at`
+
+	_, err := r.db.Exec(
+		ctx,
+		query,
+		change.UserId,
+		change.NewEmail,
+		change.TokenHash,
+		change.CancelTokenHash,
+		change.ExpiresAt,
+	)
+
+	return err
+}
+
+func (r *UserRepository) ConfirmEmailChange(ctx context.Context, tokenHash string) (*model.EmailChange, error) {
+	deleteQuery := `
+	DELETE FROM email_changes
+	WHERE token_hash = $1 AND expires_at > now()
+	RETURNING user_id, new_email, expires_at`
+
+	existsQuery := `
+	SELECT EXISTS(
+		SELECT 1 FROM users
+		WHERE lower(email) = lower($1) AND user_id <> $2
+	)`
+
+	updateQuery := `
+	UPDATE users
+	SET email = $1, is_verified = true
+	WHERE user_id = $2`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var change model.EmailChange
+
+	err = tx.QueryRow(ctx, deleteQuery, tokenHash).Scan(
+		&change.UserId,
+		&change.NewEmail,
+		&change.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var taken bool
+	if err = tx.QueryRow(ctx, existsQuery, change.NewEmail, change.UserId).Scan(&taken); err != nil {
+		return nil, err
+	}
+
+	if taken {
+		return nil, store.ErrEmailTaken
+	}
+
+	tag, err := tx.Exec(ctx, updateQuery, change.NewEmail, change.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil, pgx.ErrNoRows
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
+
+func (r *UserRepository) CancelEmailChange(ctx context.Context, cancelTokenHash string) error {
+	query := `
+	DELETE FROM email_changes
+	WHERE cancel_token_hash = $1`
+
+	tag, err := r.db.Exec(ctx, query, cancelTokenHash)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// setAvatar sets avatar URLs of the user with the avatar hash,
+// which is nil when the user hasn't uploaded an avatar
+func setAvatar(user *model.User, hash *string) {
+	if hash != nil {
+		user.Avatar = model.NewAvatar(*hash)
+	}
+}
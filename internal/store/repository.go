@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"time"
 
 	"github.com/juicyluv/astral/internal/handler/filter"
 	"github.com/juicyluv/astral/internal/model"
@@ -13,15 +14,89 @@ type UserRepository interface {
 	FindById(context.Context, int) (*model.User, error)
 	FindByEmail(context.Context, string) (*model.User, error)
 	Update(context.Context, int, *model.UpdateUserDto) error
+	SetAvatar(context.Context, int, string) error
 	Delete(context.Context, int) error
 	ConfirmEmail(context.Context, int) error
+	SetTotpSecret(context.Context, int, []byte) error
+	EnableTotp(context.Context, int, []string) error
+	DisableTotp(context.Context, int) error
+	ReplaceRecoveryCodes(context.Context, int, []string) error
+	UseRecoveryCode(context.Context, int, string) error
+	CreateEmailChange(context.Context, *model.EmailChange) error
+	ConfirmEmailChange(context.Context, string) (*model.EmailChange, error)
+	CancelEmailChange(context.Context, string) error
 }
 
 type PostRepository interface {
 	Create(context.Context, *model.Post) (int, error)
-	FindAll(context.Context, *filter.PostFilter) ([]model.Post, error)
+	FindAll(context.Context, *filter.PostFilter) ([]model.Post, *filter.Cursor, error)
 	FindById(context.Context, int) (*model.Post, error)
-	FindUserPosts(context.Context, int) ([]model.Post, error)
-	Update(context.Context, int, *model.UpdatePostDto) error
+	FindUserPosts(context.Context, int, *filter.PostFilter) ([]model.Post, *filter.Cursor, error)
+	FindFeed(context.Context, int, *filter.PageFilter) ([]model.Post, *filter.Cursor, error)
+	FindByIds(context.Context, []int) ([]model.Post, error)
+	Search(context.Context, *filter.SearchFilter) ([]model.PostSearchResult, bool, error)
+	PublishScheduled(context.Context) ([]model.Post, error)
+	Update(context.Context, int, int, *model.UpdatePostDto) error
+	FindRevisions(context.Context, int) ([]model.PostRevision, error)
+	FindRevision(context.Context, int, int) (*model.PostRevision, error)
+	InviteAuthor(context.Context, int, int, int) error
+	AcceptAuthor(context.Context, int, int) error
+	RemoveAuthor(context.Context, int, int) error
 	Delete(context.Context, int) error
 }
+
+type CommentRepository interface {
+	Create(context.Context, *model.Comment) (int, error)
+	FindById(context.Context, int) (*model.Comment, error)
+	FindPostComments(context.Context, int, *filter.CommentFilter) ([]model.Comment, *filter.Cursor, error)
+	FindReplies(context.Context, []int) ([]model.Comment, error)
+	Update(context.Context, int, string) error
+	Delete(context.Context, int) error
+}
+
+type ReactionRepository interface {
+	AddPostReaction(context.Context, int, int, string) error
+	RemovePostReaction(context.Context, int, int, string) error
+	FindUserPostReactions(context.Context, int, []int) (map[int][]string, error)
+	AddCommentReaction(context.Context, int, int, string) error
+	RemoveCommentReaction(context.Context, int, int, string) error
+	FindUserCommentReactions(context.Context, int, []int) (map[int][]string, error)
+}
+
+type FollowRepository interface {
+	Follow(context.Context, int, int) error
+	Unfollow(context.Context, int, int) error
+	Counts(context.Context, int) (*model.FollowCounts, error)
+	FindFollowers(context.Context, int, *filter.PageFilter) ([]model.Follow, *filter.Cursor, error)
+	FindFollowing(context.Context, int, *filter.PageFilter) ([]model.Follow, *filter.Cursor, error)
+	FindPostFollowers(context.Context, int) ([]int, error)
+	FindTimeline(context.Context, int, int) ([]model.TimelineEntry, error)
+}
+
+type AttachmentRepository interface {
+	TouchBlob(context.Context, string, int64, string) error
+	Create(context.Context, *model.Attachment, int64) (int, error)
+	FindById(context.Context, int) (*model.Attachment, error)
+	FindPostAttachments(context.Context, int) ([]model.Attachment, error)
+	Delete(context.Context, int) error
+	SweepBlobs(context.Context, time.Duration, int, func(string) error) (int, error)
+}
+
+type TagRepository interface {
+	FindAll(context.Context) ([]model.Tag, error)
+	FindBySlug(context.Context, string) (*model.Tag, error)
+}
+
+type TokenRepository interface {
+	Create(context.Context, *model.PersonalAccessToken) (int, error)
+	FindUserTokens(context.Context, int) ([]model.PersonalAccessToken, error)
+	FindByHash(context.Context, string) (*model.PersonalAccessToken, error)
+	Touch(context.Context, int) error
+	Delete(context.Context, int, int) error
+}
+
+type IdentityRepository interface {
+	FindUser(context.Context, string, string) (*model.User, error)
+	Create(context.Context, *model.UserIdentity) (int, error)
+	CreateWithUser(context.Context, *model.User, *model.UserIdentity) (int, error)
+}
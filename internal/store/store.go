@@ -1,9 +1,33 @@
 package store
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrEmailTaken is returned when the email belongs to another user
+var ErrEmailTaken = errors.New("email already taken")
+
+// ErrAlreadyInvited is returned when the user is already
+// an author of the post or has been invited to be one
+var ErrAlreadyInvited = errors.New("user already invited")
+
+// ErrQuotaExceeded is returned when the user has no storage left for the upload
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// ErrVersionConflict is returned when the record has been
+// changed since the version the update is based on
+var ErrVersionConflict = errors.New("version conflict")
 
 type Store interface {
 	User() UserRepository
 	Post() PostRepository
+	Tag() TagRepository
+	Comment() CommentRepository
+	Reaction() ReactionRepository
+	Follow() FollowRepository
+	Attachment() AttachmentRepository
+	Token() TokenRepository
+	Identity() IdentityRepository
 	Close(context.Context) error
 }
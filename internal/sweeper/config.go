@@ -0,0 +1,25 @@
+package sweeper
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	// Interval is how often orphaned blobs are checked
+	Interval time.Duration
+	// GracePeriod is how long blobs are kept after they have been used,
+	// so uploads in progress don't lose the content
+	GracePeriod time.Duration
+	// BatchSize is the maximum number of blobs removed at once
+	BatchSize int
+}
+
+func NewConfig() *Config {
+	return &Config{
+		Interval:    time.Duration(viper.GetInt("sweeper.interval")) * time.Second,
+		GracePeriod: time.Duration(viper.GetInt("sweeper.gracePeriod")) * time.Minute,
+		BatchSize:   viper.GetInt("sweeper.batchSize"),
+	}
+}
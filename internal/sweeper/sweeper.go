@@ -0,0 +1,71 @@
+package sweeper
+
+import (
+	"context"
+	"time"
+
+	"github.com/juicyluv/astral/internal/blob"
+	"github.com/juicyluv/astral/internal/store"
+	"go.uber.org/zap"
+)
+
+// Sweeper removes blobs which are not referred by any attachments,
+// such as the files of deleted posts. It is safe to run the sweeper
+// on several instances at once, blobs are removed by one of them only.
+type Sweeper struct {
+	store  store.Store
+	blobs  blob.Store
+	logger *zap.SugaredLogger
+	cfg    *Config
+}
+
+func NewSweeper(store store.Store, blobs blob.Store, logger *zap.SugaredLogger, cfg *Config) *Sweeper {
+	return &Sweeper{
+		store:  store,
+		blobs:  blobs,
+		logger: logger,
+		cfg:    cfg,
+	}
+}
+
+// Run removes orphaned blobs every interval until the context is done
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweep(ctx)
+		}
+	}
+}
+
+// sweep removes orphaned blobs in batches until there are none left
+func (s *Sweeper) sweep(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Interval)
+	defer cancel()
+
+	total := 0
+
+	for {
+		removed, err := s.store.Attachment().SweepBlobs(ctx, s.cfg.GracePeriod, s.cfg.BatchSize, func(hash string) error {
+			return s.blobs.Delete(ctx, "attachments/"+hash)
+		})
+		if err != nil {
+			s.logger.Errorf("could not sweep orphaned blobs: %v", err)
+			break
+		}
+
+		total += removed
+		if removed < s.cfg.BatchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		s.logger.Infof("%d orphaned blobs have been removed", total)
+	}
+}
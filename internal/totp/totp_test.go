@@ -0,0 +1,109 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret of the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 Appendix B SHA1 vectors, which have 8 digits,
+	// truncated to the last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if code != tt.code {
+			t.Errorf("Code(%d) = %s, expected %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name  string
+		steps int64
+		ok    bool
+	}{
+		{"current step", 0, true},
+		{"previous step", -1, true},
+		{"next step", 1, true},
+		{"two steps before", -2, false},
+		{"two steps after", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, now.Add(time.Duration(tt.steps*Period)*time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			step, ok := Validate(rfcSecret, code, now)
+			if ok != tt.ok {
+				t.Fatalf("Validate() = %v, expected %v", ok, tt.ok)
+			}
+
+			// The step identifies the code, so it can't be used again
+			if ok && step != Step(now)+tt.steps {
+				t.Errorf("Validate() step = %d, expected %d", step, Step(now)+tt.steps)
+			}
+		})
+	}
+}
+
+func TestValidateMalformedCode(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	code, err := Code(rfcSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []string{"", code[:5], code + "0", "12345a", "0" + code} {
+		if _, ok := Validate(rfcSecret, c, now); ok {
+			t.Errorf("Validate(%q) accepted the code", c)
+		}
+	}
+
+	// Surrounding whitespace is typed by users and ignored
+	if _, ok := Validate(rfcSecret, " "+code+"\n", now); !ok {
+		t.Error("Validate() rejected the code with whitespace")
+	}
+}
+
+func TestValidateWrongSecret(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := Code(rfcSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := Validate(secret, code, now); ok {
+		t.Error("Validate() accepted the code of another secret")
+	}
+}
@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN totp_secret bytea;
+ALTER TABLE users ADD COLUMN totp_enabled boolean not null default false;
+
+CREATE TABLE IF NOT EXISTS recovery_codes(
+    recovery_code_id serial primary key not null,
+    user_id int not null,
+    code_hash text not null,
+    used_at timestamptz,
+
+    foreign key(user_id) references users(user_id) on delete cascade
+);
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS personal_access_tokens(
+    token_id serial primary key not null,
+    user_id int not null,
+    name text not null,
+    token_hash text not null unique,
+    scopes text[] not null,
+    created_at timestamptz not null default now(),
+    expires_at timestamptz,
+    last_used_at timestamptz,
+
+    foreign key(user_id) references users(user_id) on delete cascade
+);
\ No newline at end of file
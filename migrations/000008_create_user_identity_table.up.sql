@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS user_identities(
+    identity_id serial primary key not null,
+    user_id int not null,
+    provider text not null,
+    subject text not null,
+    email text not null,
+    created_at timestamptz not null default now(),
+
+    unique(provider, subject),
+    foreign key(user_id) references users(user_id) on delete cascade
+);
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS email_changes(
+    user_id int primary key not null,
+    new_email text not null,
+    token_hash text not null unique,
+    cancel_token_hash text not null unique,
+    created_at timestamptz not null default now(),
+    expires_at timestamptz not null,
+
+    foreign key(user_id) references users(user_id) on delete cascade
+);
\ No newline at end of file
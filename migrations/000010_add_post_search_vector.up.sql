@@ -0,0 +1,7 @@
+ALTER TABLE posts
+ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', title), 'A') ||
+    setweight(to_tsvector('english', content), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS posts_search_vector_idx ON posts USING gin(search_vector);
\ No newline at end of file
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS tags(
+    tag_id serial primary key not null,
+    slug text not null unique
+);
+
+CREATE TABLE IF NOT EXISTS post_tags(
+    post_id int not null,
+    tag_id int not null,
+
+    primary key(post_id, tag_id),
+    foreign key(post_id) references posts(post_id) on delete cascade,
+    foreign key(tag_id) references tags(tag_id) on delete cascade
+);
+
+CREATE INDEX IF NOT EXISTS post_tags_tag_id_idx ON post_tags(tag_id);
\ No newline at end of file
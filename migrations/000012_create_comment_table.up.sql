@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS comments(
+    comment_id serial primary key not null,
+    post_id int not null,
+    parent_id int,
+    author_id int,
+    content text not null,
+    created_at timestamptz not null default now(),
+    updated_at timestamptz not null default now(),
+    deleted_at timestamptz,
+
+    foreign key(post_id) references posts(post_id) on delete cascade,
+    foreign key(parent_id) references comments(comment_id) on delete cascade,
+    foreign key(author_id) references users(user_id) on delete set null
+);
+
+CREATE INDEX IF NOT EXISTS comments_post_id_idx ON comments(post_id, created_at, comment_id);
+CREATE INDEX IF NOT EXISTS comments_parent_id_idx ON comments(parent_id);
\ No newline at end of file
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS post_reactions(
+    post_id int not null,
+    user_id int not null,
+    kind text not null,
+    created_at timestamptz not null default now(),
+
+    primary key(post_id, user_id, kind),
+    foreign key(post_id) references posts(post_id) on delete cascade,
+    foreign key(user_id) references users(user_id) on delete cascade
+);
+
+CREATE TABLE IF NOT EXISTS comment_reactions(
+    comment_id int not null,
+    user_id int not null,
+    kind text not null,
+    created_at timestamptz not null default now(),
+
+    primary key(comment_id, user_id, kind),
+    foreign key(comment_id) references comments(comment_id) on delete cascade,
+    foreign key(user_id) references users(user_id) on delete cascade
+);
+
+ALTER TABLE posts ADD COLUMN reaction_counts jsonb not null default '{}';
+ALTER TABLE comments ADD COLUMN reaction_counts jsonb not null default '{}';
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE posts ADD COLUMN status text not null default 'published'
+    check (status in ('draft', 'scheduled', 'published', 'archived'));
+ALTER TABLE posts ADD COLUMN published_at timestamptz;
+
+UPDATE posts SET published_at = created_at;
+
+CREATE INDEX IF NOT EXISTS posts_scheduled_idx ON posts(published_at) WHERE status = 'scheduled';
\ No newline at end of file
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS post_revisions(
+    revision_id serial primary key not null,
+    post_id int not null,
+    revision int not null,
+    title text not null,
+    content text not null,
+    editor_id int,
+    created_at timestamptz not null default now(),
+
+    unique(post_id, revision),
+    foreign key(post_id) references posts(post_id) on delete cascade,
+    foreign key(editor_id) references users(user_id) on delete set null
+);
+
+-- Current state of existing posts becomes their first revision
+INSERT INTO post_revisions(post_id, revision, title, content, editor_id, created_at)
+SELECT post_id, 1, title, content, author_id, updated_at FROM posts;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE posts DROP COLUMN IF EXISTS version;
+ALTER TABLE users DROP COLUMN IF EXISTS version;
\ No newline at end of file
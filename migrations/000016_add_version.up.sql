@@ -0,0 +1,2 @@
+ALTER TABLE posts ADD COLUMN version int not null default 1;
+ALTER TABLE users ADD COLUMN version int not null default 1;
\ No newline at end of file
@@ -0,0 +1,7 @@
+-- Rows which have been deleted by the up migration are not restored,
+-- user_post keeps the authors which have been copied from posts
+DROP INDEX IF EXISTS user_post_post_id_idx;
+ALTER TABLE user_post DROP CONSTRAINT IF EXISTS user_post_pkey;
+ALTER TABLE user_post DROP COLUMN IF EXISTS invited_by;
+ALTER TABLE user_post DROP COLUMN IF EXISTS invited_at;
+ALTER TABLE user_post DROP COLUMN IF EXISTS accepted_at;
\ No newline at end of file
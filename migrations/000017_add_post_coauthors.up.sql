@@ -0,0 +1,15 @@
+-- Authors of existing posts are taken from posts, the table has
+-- been out of sync since post authors could be changed.
+-- The deleted rows can't be restored by the down migration.
+DELETE FROM user_post;
+
+ALTER TABLE user_post ADD COLUMN invited_by int;
+ALTER TABLE user_post ADD COLUMN invited_at timestamptz not null default now();
+ALTER TABLE user_post ADD COLUMN accepted_at timestamptz;
+ALTER TABLE user_post ADD PRIMARY KEY (user_id, post_id);
+ALTER TABLE user_post ADD FOREIGN KEY (invited_by) REFERENCES users(user_id) ON DELETE SET NULL;
+
+INSERT INTO user_post(user_id, post_id, invited_at, accepted_at)
+SELECT author_id, post_id, created_at, created_at FROM posts;
+
+CREATE INDEX IF NOT EXISTS user_post_post_id_idx ON user_post(post_id);
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS posts_published_at_idx;
+DROP TABLE IF EXISTS follows;
\ No newline at end of file
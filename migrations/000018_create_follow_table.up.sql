@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS follows(
+    follower_id int not null,
+    followee_id int not null,
+    created_at timestamptz not null default now(),
+
+    primary key(follower_id, followee_id),
+    check(follower_id <> followee_id),
+    foreign key(follower_id) references users(user_id) on delete cascade,
+    foreign key(followee_id) references users(user_id) on delete cascade
+);
+
+CREATE INDEX IF NOT EXISTS follows_follower_id_idx ON follows(follower_id, created_at, followee_id);
+CREATE INDEX IF NOT EXISTS follows_followee_id_idx ON follows(followee_id, created_at, follower_id);
+CREATE INDEX IF NOT EXISTS posts_published_at_idx ON posts(published_at, post_id) WHERE status = 'published';
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE users DROP COLUMN IF EXISTS display_name;
+ALTER TABLE users DROP COLUMN IF EXISTS bio;
+ALTER TABLE users DROP COLUMN IF EXISTS location;
+ALTER TABLE users DROP COLUMN IF EXISTS links;
+ALTER TABLE users DROP COLUMN IF EXISTS avatar;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN display_name text not null default '';
+ALTER TABLE users ADD COLUMN bio text not null default '';
+ALTER TABLE users ADD COLUMN location text not null default '';
+ALTER TABLE users ADD COLUMN links text[] not null default '{}';
+ALTER TABLE users ADD COLUMN avatar text;
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS attachments;
+DROP TABLE IF EXISTS blobs;
\ No newline at end of file
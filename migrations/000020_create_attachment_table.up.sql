@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS blobs(
+    hash text primary key not null,
+    size bigint not null,
+    content_type text not null,
+    created_at timestamptz not null default now(),
+    last_used_at timestamptz not null default now()
+);
+
+CREATE TABLE IF NOT EXISTS attachments(
+    attachment_id serial primary key not null,
+    post_id int not null,
+    user_id int,
+    file_name text not null,
+    content_type text not null,
+    size bigint not null,
+    hash text not null,
+    created_at timestamptz not null default now(),
+
+    foreign key(post_id) references posts(post_id) on delete cascade,
+    foreign key(user_id) references users(user_id) on delete set null,
+    foreign key(hash) references blobs(hash)
+);
+
+CREATE INDEX IF NOT EXISTS attachments_post_id_idx ON attachments(post_id);
+CREATE INDEX IF NOT EXISTS attachments_user_id_idx ON attachments(user_id);
+CREATE INDEX IF NOT EXISTS attachments_hash_idx ON attachments(hash);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE post_revisions DROP COLUMN IF EXISTS format;
+ALTER TABLE posts DROP COLUMN IF EXISTS content_html;
+ALTER TABLE posts DROP COLUMN IF EXISTS format;
\ No newline at end of file
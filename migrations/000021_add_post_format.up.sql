@@ -0,0 +1,6 @@
+ALTER TABLE posts ADD COLUMN format text not null default 'plain'
+    check (format in ('plain', 'markdown'));
+-- HTML of existing posts is left empty and rendered from the content on
+-- read, so it is the same as the HTML of posts created after the migration
+ALTER TABLE posts ADD COLUMN content_html text not null default '';
+ALTER TABLE post_revisions ADD COLUMN format text not null default 'plain';
\ No newline at end of file